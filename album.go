@@ -0,0 +1,75 @@
+package musictagger
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// AlbumJSONFile is the name of the file WriteJSON creates.
+const AlbumJSONFile = "album.json"
+
+// Album holds the consolidated metadata of the tracks of one album.
+type Album struct {
+	Artist string  `json:"artist"`
+	Album  string  `json:"album"`
+	Year   int     `json:"year,omitempty"`
+	Genre  string  `json:"genre,omitempty"`
+	Tracks []Track `json:"tracks"`
+}
+
+// Track is a single entry in an album's track list.
+type Track struct {
+	Disc   int    `json:"disc,omitempty"`
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Source string `json:"source"`
+}
+
+// NewAlbum builds an Album from the music of one album directory. Album-level
+// fields come from the first track, and tracks are ordered by disc and track
+// number.
+func NewAlbum(music []Music) Album {
+	var album Album
+	if len(music) == 0 {
+		return album
+	}
+
+	first := music[0].Metadata
+	album.Artist = first.Artist()
+	if first.AlbumArtist() != "" {
+		album.Artist = first.AlbumArtist()
+	}
+	album.Album = first.Album()
+	album.Year = first.Year()
+	album.Genre = first.Genre()
+
+	for _, m := range music {
+		disc, _ := m.Metadata.Disc()
+		track, _ := m.Metadata.Track()
+		album.Tracks = append(album.Tracks, Track{
+			Disc:   disc,
+			Number: track,
+			Title:  m.Metadata.Title(),
+			Source: m.Path,
+		})
+	}
+	sort.SliceStable(album.Tracks, func(i, j int) bool {
+		if album.Tracks[i].Disc != album.Tracks[j].Disc {
+			return album.Tracks[i].Disc < album.Tracks[j].Disc
+		}
+		return album.Tracks[i].Number < album.Tracks[j].Number
+	})
+
+	return album
+}
+
+// WriteJSON writes the album as album.json in dir.
+func (a Album) WriteJSON(dir string) error {
+	b, err := json.MarshalIndent(a, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, AlbumJSONFile), b, 0644)
+}
@@ -0,0 +1,93 @@
+package musictagger
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAlbumWriteJSON(t *testing.T) {
+	music := []Music{
+		{"/src/c.flac", mockTag{album: "Album", artist: "Guest", albumArtist: "Artist", genre: "Rock", year: 1999, track: 2, title: "Second", disc: 1}},
+		{"/src/a.flac", mockTag{album: "Album", artist: "Artist", genre: "Rock", year: 1999, track: 1, title: "Bonus", disc: 2}},
+		{"/src/b.flac", mockTag{album: "Album", artist: "Artist", genre: "Rock", year: 1999, track: 1, title: "First", disc: 1}},
+	}
+
+	dir := t.TempDir()
+	if err := NewAlbum(music).WriteJSON(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, AlbumJSONFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Album
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := Album{
+		Artist: "Artist",
+		Album:  "Album",
+		Year:   1999,
+		Genre:  "Rock",
+		Tracks: []Track{
+			{Disc: 1, Number: 1, Title: "First", Source: "/src/b.flac"},
+			{Disc: 1, Number: 2, Title: "Second", Source: "/src/c.flac"},
+			{Disc: 2, Number: 1, Title: "Bonus", Source: "/src/a.flac"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("album.json = %+v, want %+v", got, want)
+	}
+}
+
+func TestAlbumWriteNFO(t *testing.T) {
+	album := NewAlbum([]Music{
+		{"/src/b.flac", mockTag{album: "Album", artist: "Artist", genre: "Rock", year: 1999, track: 2, title: "Second"}},
+		{"/src/a.flac", mockTag{album: "Album", artist: "Artist", genre: "Rock", year: 1999, track: 1, title: "First"}},
+	})
+
+	dir := t.TempDir()
+	if err := album.WriteNFO(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, AlbumNFOFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got albumNFO
+	if err := xml.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := albumNFO{
+		XMLName:     xml.Name{Local: "album"},
+		Title:       "Album",
+		Artist:      "Artist",
+		AlbumArtist: "Artist",
+		Year:        1999,
+		Genre:       "Rock",
+		Tracks: []nfoTrack{
+			{Position: 1, Title: "First"},
+			{Position: 2, Title: "Second"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("album.nfo = %+v, want %+v", got, want)
+	}
+
+	// an existing album.nfo is left alone
+	album.Album = "Other"
+	if err := album.WriteNFO(dir); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(filepath.Join(dir, AlbumNFOFile)); string(again) != string(b) {
+		t.Errorf("album.nfo was overwritten with %s", again)
+	}
+}
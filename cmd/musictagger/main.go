@@ -1,13 +1,22 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io"
-	"io/fs"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/dhowden/tag"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/pkazmierczak/musictagger"
@@ -18,14 +27,72 @@ var (
 	replacements = flag.String("replacements", "replacements.json", "Path to the json file containing a map of replacements")
 	musicLib     = flag.String("library", "", "Path to the music library")
 	source       = flag.String("source", ".", "source directory, defaults to current dir")
+	interactive  = flag.Bool("interactive", false, "Show every album's moves and ask before making them, then ask about the non-music moves")
 	dry          = flag.Bool("dry", false, "Dry run (no actual files moved)")
+	mode         = flag.String("mode", "move", "How files are placed in the library: move, copy, hardlink or symlink")
+	moveRetries  = flag.Int("move-retries", 0, "How many times to retry a move that fails with a transient error")
+	flat         = flag.Bool("flat", false, "Put all files directly in the library, without album directories")
+	stripTrack   = flag.Bool("strip-track-prefix", false, "Strip a leading track number from titles when it matches the track tag")
+	missingTrack = flag.String("missing-track", "zero", "How to name files without a track number: zero, index or filename")
+	trackPadding = flag.Bool("dynamic-track-padding", false, "Pad track numbers to the width of each album's largest track number, instead of always 2 digits")
+	collapseSeps = flag.Bool("collapse-separators", false, "Collapse repeated separators left behind by empty tags")
+	groupAlbums  = flag.Bool("group-albums", false, "Keep the tracks of a source directory together in the album directory most of them belong to")
+	normalize    = flag.Bool("normalize-names", false, "With -group-albums, treat album directories that only differ in diacritics or spacing as one")
+	various      = flag.Bool("various-artists", false, "File compilations under \"Various Artists\" instead of splitting them by track artist")
+	extensions   = flag.String("extensions", strings.Join(musictagger.DefaultAudioExtensions, ","), "Comma-separated extensions of the files to read tags from, empty for all files")
+	companions   = flag.String("companions", strings.Join(internal.DefaultCompanionExtensions, ","), "Comma-separated extensions of the non-music files that move with an album, empty for all files")
+	ignore       = flag.String("ignore", "", "Comma-separated glob patterns of file and directory names to skip, e.g. *.part,@eaDir,.*; directories can also list them in a "+internal.IgnoreFileName+" file")
+	nonMusic     = flag.String("move-non-music-to", "", "Move files from directories without any music into this directory")
+	onConflict   = flag.String("on-conflict", "skip", "What to do when a target file already exists: skip, rename or overwrite")
+	journalPath  = flag.String("journal", "", "Append every move to this journal file so it can be undone later")
+	showTags     = flag.String("show-tags", "", "Print the tags of the given file and where it would go in the library, and exit")
+	undo         = flag.String("undo", "", "Revert the moves recorded in the given journal file and exit")
+	require      = flag.String("require", "", "Comma-separated tags a file must have to be moved: artist, album, title or track")
+	limit        = flag.Int("limit", 0, "Only process this many album directories, 0 for all (-move-non-music-to isn't limited)")
+	since        = flag.String("since", "", "Only process files modified since this date (2006-01-02) or this long ago (e.g. 72h)")
+	concurrency  = flag.Int("concurrency", 1, "Number of albums to process in parallel")
+	pruneEmpty   = flag.Bool("prune-empty", false, "Remove directories left empty in the source directory afterwards")
+	playlist     = flag.Bool("playlist", false, "Write an .m3u8 playlist into each album directory")
+	articles     = flag.String("strip-articles", "", "Comma-separated leading articles to strip from artist names, e.g. The,A,An")
+	articleStyle = flag.String("article-style", "remove", "What to do with stripped articles: remove or move-to-end")
+	initials     = flag.Bool("artist-initial", false, "File albums under a directory named after the first letter of their artist")
+	windowsSafe  = flag.Bool("windows-safe", false, "Replace characters that are invalid in Windows file names")
+	albumJSON    = flag.Bool("album-json", false, "Write an album.json with the album's metadata into each album directory")
+	jsonSummary  = flag.Bool("json", false, "Print the summary at the end of the run as JSON on stdout, and a -dry plan on stderr")
+	logFormat    = flag.String("log-format", "text", "The log format: text or json")
+	nfo          = flag.Bool("nfo", false, "Write an album.nfo for Jellyfin, Plex or Kodi into each album directory that doesn't have one")
 	loglvl       = flag.String("log-level", "info", "The log level")
 )
 
 func main() {
 	flag.Parse()
 
-	if *musicLib == "" {
+	// setup logging
+	logLevel, err := log.ParseLevel(*loglvl)
+	if err != nil {
+		logLevel = log.InfoLevel
+		log.Warnf("invalid log-level %s, set to %v", *loglvl, log.InfoLevel)
+	}
+	log.SetLevel(logLevel)
+
+	switch *logFormat {
+	case "text":
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.Fatalf("invalid log-format %s, must be text or json", *logFormat)
+	}
+
+	if *undo != "" {
+		reverted, err := internal.Undo(*undo, internal.OSMover{Mode: internal.ModeMove})
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Infof("reverted %d moves", reverted)
+		return
+	}
+
+	if *musicLib == "" && *showTags == "" {
 		log.Fatal("must provide an absolute path to the music library")
 	}
 
@@ -43,75 +110,251 @@ func main() {
 		}
 	}
 
-	// setup logging
-	logLevel, err := log.ParseLevel(*loglvl)
-	if err != nil {
-		logLevel = log.InfoLevel
-		log.Warnf("invalid log-level %s, set to %v", *loglvl, log.InfoLevel)
+	placement := internal.Mode(*mode)
+	if !slices.Contains(internal.Modes, placement) {
+		log.Fatalf("invalid mode %s, must be one of %v", *mode, internal.Modes)
 	}
-	log.SetLevel(logLevel)
 
-	musicLibrary, err := musictagger.GetAllTags(*source)
-	if err != nil {
-		log.Fatal(err)
+	conflictPolicy := internal.ConflictPolicy(*onConflict)
+	switch conflictPolicy {
+	case internal.ConflictSkip, internal.ConflictRename, internal.ConflictOverwrite:
+	default:
+		log.Fatalf("invalid on-conflict policy %s, must be one of %s, %s or %s",
+			*onConflict, internal.ConflictSkip, internal.ConflictRename, internal.ConflictOverwrite)
 	}
 
-	for originalDir, music := range musicLibrary {
-		var newDir string
-		for _, m := range music {
-			computedPath := internal.ComputeTargetPath(m.Metadata, m.Path, replacementsMap)
-			newDir = filepath.Dir(filepath.Join(*musicLib, computedPath))
-			newPath := filepath.Join(*musicLib, computedPath)
+	trackStrategy := internal.MissingTrackStrategy(*missingTrack)
+	switch trackStrategy {
+	case internal.MissingTrackZero, internal.MissingTrackIndex, internal.MissingTrackFilename:
+	default:
+		log.Fatalf("invalid missing-track strategy %s, must be one of %s, %s or %s",
+			*missingTrack, internal.MissingTrackZero, internal.MissingTrackIndex, internal.MissingTrackFilename)
+	}
 
-			if m.Path == newPath {
-				continue
-			}
+	style := internal.ArticleStyle(*articleStyle)
+	switch style {
+	case internal.ArticleRemove, internal.ArticleMoveToEnd:
+	default:
+		log.Fatalf("invalid article-style %s, must be one of %s or %s",
+			*articleStyle, internal.ArticleRemove, internal.ArticleMoveToEnd)
+	}
 
-			log.Infof("renaming %s to %s\n", m.Path, filepath.Join(*musicLib, computedPath))
+	pathOpts := internal.PathOptions{
+		Flat:                *flat,
+		StripTrackPrefix:    *stripTrack,
+		CollapseSeparators:  *collapseSeps,
+		MissingTrack:        trackStrategy,
+		DynamicTrackPadding: *trackPadding,
+		ArticleStyle:        style,
+		ArtistInitial:       *initials,
+		WindowsSafe:         *windowsSafe,
+	}
+
+	if *articles != "" {
+		pathOpts.StripArticles = strings.Split(*articles, ",")
+	}
+
+	if *showTags != "" {
+		if err := internal.DescribeFile(os.Stdout, *showTags, *musicLib, replacementsMap, pathOpts); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-			if *dry {
-				continue
+	var requireFields []string
+	if *require != "" {
+		requireFields = strings.Split(*require, ",")
+		for _, f := range requireFields {
+			if !slices.Contains(internal.RequiredFields, f) {
+				log.Fatalf("invalid required tag %s, must be one of %v", f, internal.RequiredFields)
 			}
+		}
+	}
+
+	filters := []musictagger.Filter{internal.IgnoreFiles(*source, internal.IgnoreFileName)}
+	if *ignore != "" {
+		filters = append(filters, musictagger.IgnorePatterns(strings.Split(*ignore, ",")...))
+	}
+
+	scanFilters := slices.Clone(filters)
+	if *extensions != "" {
+		scanFilters = append(scanFilters, musictagger.AudioFiles(strings.Split(*extensions, ",")...))
+	}
+
+	musicLibrary, failed, err := musictagger.GetAllTags(*source, scanFilters...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, fe := range failed {
+		// without -extensions every file is probed, most of them legitimately
+		// without tags, while with it only audio files are, whose tags should
+		// be readable
+		if *extensions == "" && errors.Is(fe.Err, tag.ErrNoTagsFound) {
+			log.WithField("path", fe.Path).Debug(fe)
+		} else {
+			log.WithField("path", fe.Path).Warn(fe)
+		}
+	}
+
+	var cutoff time.Time
+	if *since != "" {
+		cutoff, err = parseSince(*since)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	planner := internal.Planner{
+		Library:       *musicLib,
+		Replacements:  replacementsMap,
+		PathOptions:   pathOpts,
+		OnConflict:    conflictPolicy,
+		Mode:          placement,
+		Compilations:  *various,
+		Filters:       filters,
+		RequireFields: requireFields,
+		MaxAlbums:     *limit,
+		ModifiedSince: cutoff,
+	}
+	if *companions != "" {
+		planner.CompanionExtensions = strings.Split(*companions, ",")
+	}
+	plans, err := planner.Plan(musicLibrary)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-			if _, err := os.Stat(newDir); os.IsNotExist(err) {
-				err := os.Mkdir(newDir, 0755)
-				if err != nil {
-					log.Fatal(err)
+	var extras []internal.RenamePlan
+	if *nonMusic != "" {
+		extras, err = planner.PlanNonMusic(*source, musicLibrary, *nonMusic)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *dry {
+		moves := extras
+		for _, album := range plans {
+			moves = append(moves, album.Moves()...)
+		}
+		// with -json, stdout is kept for the summary alone
+		var planOut io.Writer = os.Stdout
+		if *jsonSummary {
+			planOut = os.Stderr
+		}
+		if err := internal.WritePlan(planOut, moves); err != nil {
+			log.Fatal(err)
+		}
+		if *pruneEmpty {
+			// only moves take files out of the source directory
+			gone := map[string]bool{}
+			if placement == internal.ModeMove {
+				for _, m := range moves {
+					gone[m.Source] = true
 				}
 			}
-
-			if err := os.Rename(m.Path, newPath); err != nil {
+			if _, err := internal.PruneEmptyDirs(*source, []string{*musicLib, *nonMusic}, gone, true, filters...); err != nil {
 				log.Warn(err)
 			}
 		}
+		report(internal.Summarize(plans, extras))
+		return
+	}
 
-		if originalDir == newDir {
-			continue
+	runner := internal.Runner{
+		Mover:     internal.OSMover{Mode: placement, Retries: *moveRetries},
+		AlbumJSON: *albumJSON,
+		NFO:       *nfo,
+		Playlist:  *playlist,
+	}
+	if *interactive {
+		runner.Confirm = confirm(os.Stdin, os.Stdout)
+	}
+	if *journalPath != "" {
+		runner.Journal, err = internal.OpenJournal(*journalPath, placement)
+		if err != nil {
+			log.Fatal(err)
 		}
+		defer runner.Journal.Close()
+	}
 
-		// if there's any other files in the directory, copy them
-		if err := filepath.WalkDir(originalDir, func(s string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return err
-			}
-			if !d.IsDir() {
-				log.Infof("renaming %s to %s\n", filepath.Join(
-					originalDir, d.Name()),
-					filepath.Join(newDir, d.Name()),
-				)
-				if *dry {
-					return nil
-				}
-				if err := os.Rename(
-					filepath.Join(originalDir, d.Name()),
-					filepath.Join(newDir, d.Name()),
-				); err != nil {
-					log.Warn(err)
-				}
-			}
-			return nil
-		}); err != nil {
+	// stop cleanly on Ctrl-C instead of dying in the middle of a move
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = internal.ForEach(ctx, plans, *concurrency, func(ctx context.Context, album internal.AlbumPlan) {
+		if err := runner.RunAlbum(ctx, album); err != nil {
+			log.Warnf("interrupted while moving %s, it is only partly moved", album.Source)
+		}
+	})
+	// whatever albums made it get their album files, even when interrupted
+	runner.WriteAlbumFiles()
+	if err != nil {
+		log.Fatalf("interrupted: %v", err)
+	}
+
+	if err := runner.RunNonMusic(ctx, *source, extras); err != nil {
+		log.Fatalf("interrupted: %v", err)
+	}
+
+	if *pruneEmpty {
+		if _, err := internal.PruneEmptyDirs(*source, []string{*musicLib, *nonMusic}, nil, false, filters...); err != nil {
 			log.Warn(err)
 		}
 	}
+	report(runner.Summary())
+}
+
+// report logs the summary of a run, or prints it as JSON with -json.
+func report(s internal.Summary) {
+	if *jsonSummary {
+		if err := json.NewEncoder(os.Stdout).Encode(s); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	log.Infof("processed %d albums: %d files moved, %d skipped, %d errors",
+		s.AlbumsProcessed, s.FilesMoved, s.FilesSkipped, s.Errors)
+}
+
+// parseSince parses the -since flag, either a date or a duration back from
+// now.
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %s, must be a date like 2006-01-02 or a duration like 72h", s)
+	}
+	return t, nil
+}
+
+// confirm returns a Runner.Confirm that shows each album's moves on out and
+// asks for a yes or no on in. Albums with nothing to move are approved
+// without asking.
+func confirm(in io.Reader, out io.Writer) func(internal.AlbumPlan) bool {
+	var mu sync.Mutex
+	answers := bufio.NewScanner(in)
+	return func(album internal.AlbumPlan) bool {
+		if len(album.Moves()) == 0 {
+			return true
+		}
+
+		// albums processed in parallel take turns asking
+		mu.Lock()
+		defer mu.Unlock()
+
+		fmt.Fprintln(out)
+		if err := internal.WritePlan(out, album.Moves()); err != nil {
+			log.Warn(err)
+			return false
+		}
+		fmt.Fprintf(out, "move %s? [y/N] ", album.Source)
+		if !answers.Scan() {
+			return false
+		}
+		answer := strings.ToLower(strings.TrimSpace(answers.Text()))
+		return answer == "y" || answer == "yes"
+	}
 }
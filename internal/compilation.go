@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/dhowden/tag"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+// VariousArtists is the album artist compilations are filed under.
+const VariousArtists = "Various Artists"
+
+// compilationFlags are the raw tag keys used by the different formats to mark
+// a compilation: ID3v2, MP4 and Vorbis comments respectively.
+var compilationFlags = []string{"TCMP", "cpil", "compilation"}
+
+// IsCompilation reports whether music is a compilation, i.e. any track carries
+// a compilation flag, or the tracks have different artists and none of them
+// has an album artist that would keep them together.
+func IsCompilation(music []musictagger.Music) bool {
+	// a flag on any track wins over the album artists of the others
+	for _, m := range music {
+		raw := m.Metadata.Raw()
+		for _, k := range compilationFlags {
+			if v, ok := raw[k]; ok {
+				switch fmt.Sprint(v) {
+				case "1", "true":
+					return true
+				}
+			}
+		}
+	}
+
+	artists := map[string]bool{}
+	for _, m := range music {
+		if m.Metadata.AlbumArtist() != "" {
+			return false
+		}
+		artists[m.Metadata.Artist()] = true
+	}
+	return len(artists) > 1
+}
+
+// compilationTag files a track under VariousArtists.
+type compilationTag struct {
+	tag.Metadata
+}
+
+func (compilationTag) AlbumArtist() string { return VariousArtists }
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+func TestIsCompilation(t *testing.T) {
+	tests := []struct {
+		name  string
+		music []musictagger.Music
+		want  bool
+	}{
+		{
+			"single artist",
+			[]musictagger.Music{
+				{Path: "1.flac", Metadata: mockTag{artist: "a", album: "album", track: 1}},
+				{Path: "2.flac", Metadata: mockTag{artist: "a", album: "album", track: 2}},
+			},
+			false,
+		},
+		{
+			"mixed artists",
+			[]musictagger.Music{
+				{Path: "1.flac", Metadata: mockTag{artist: "a", album: "album", track: 1}},
+				{Path: "2.flac", Metadata: mockTag{artist: "b", album: "album", track: 2}},
+			},
+			true,
+		},
+		{
+			"mixed artists with album artist",
+			[]musictagger.Music{
+				{Path: "1.flac", Metadata: mockTag{artist: "a", albumArtist: "a", album: "album", track: 1}},
+				{Path: "2.flac", Metadata: mockTag{artist: "b", albumArtist: "a", album: "album", track: 2}},
+			},
+			false,
+		},
+		{
+			"compilation flag",
+			[]musictagger.Music{
+				{Path: "1.flac", Metadata: mockTag{artist: "a", album: "album", track: 1, raw: map[string]interface{}{"compilation": "1"}}},
+			},
+			true,
+		},
+		{
+			"compilation flag after an album artist",
+			[]musictagger.Music{
+				{Path: "1.flac", Metadata: mockTag{artist: "a", albumArtist: "a", album: "album", track: 1}},
+				{Path: "2.flac", Metadata: mockTag{artist: "b", album: "album", track: 2, raw: map[string]interface{}{"compilation": "1"}}},
+			},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsCompilation(tt.music); got != tt.want {
+				t.Errorf("IsCompilation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlannerCompilations(t *testing.T) {
+	library := t.TempDir()
+	planner := Planner{Library: library, Compilations: true, Replacements: map[string]string{" ": "_"}}
+
+	source := t.TempDir()
+	plan, err := planner.PlanAlbum(source, []musictagger.Music{
+		{Path: filepath.Join(source, "1.flac"), Metadata: mockTag{artist: "a", album: "hits", track: 1, title: "one"}},
+		{Path: filepath.Join(source, "2.flac"), Metadata: mockTag{artist: "b", album: "hits", track: 2, title: "two"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(library, "various_artists-hits"); plan.Target != want {
+		t.Errorf("PlanAlbum() target = %v, want %v", plan.Target, want)
+	}
+	for _, m := range plan.Music {
+		if filepath.Dir(m.Target) != plan.Target {
+			t.Errorf("%s planned outside the album directory: %s", m.Source, m.Target)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/dhowden/tag"
+)
+
+// DescribeFile writes the tags read from the file at path, the raw tags
+// underneath them, and the target ComputeTargetPath gives it in library.
+// The target is computed for the file on its own, so choices that need the
+// rest of its album, such as compilations or -missing-track index, aren't
+// reflected.
+func DescribeFile(w io.Writer, path, library string, replacements map[string]string, opts PathOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	m, err := tag.ReadFrom(f)
+	if err != nil {
+		return fmt.Errorf("reading tags of %s: %w", path, err)
+	}
+
+	track, tracks := m.Track()
+	disc, discs := m.Disc()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, field := range [][2]string{
+		{"file", path},
+		{"format", fmt.Sprintf("%s %s", m.FileType(), m.Format())},
+		{"title", m.Title()},
+		{"artist", m.Artist()},
+		{"album artist", m.AlbumArtist()},
+		{"album", m.Album()},
+		{"year", fmt.Sprint(m.Year())},
+		{"genre", m.Genre()},
+		{"track", fmt.Sprintf("%d/%d", track, tracks)},
+		{"disc", fmt.Sprintf("%d/%d", disc, discs)},
+	} {
+		fmt.Fprintf(tw, "%s:\t%s\n", field[0], field[1])
+	}
+
+	raw := m.Raw()
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintln(tw, "raw:\t")
+	for _, k := range keys {
+		fmt.Fprintf(tw, "  %s:\t%v\n", k, raw[k])
+	}
+
+	fmt.Fprintf(tw, "target:\t%s\n", filepath.Join(library, ComputeTargetPath(m, path, replacements, opts)))
+	return tw.Flush()
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDescribeFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "track.mp3")
+
+	// a minimal mp3 file carrying an ID3v1 tag
+	field := func(s string, n int) string {
+		return s + strings.Repeat("\x00", n-len(s))
+	}
+	writeTestFile(t, path, "\xff\xfb"+strings.Repeat("\x00", 200)+"TAG"+
+		field("Title", 30)+field("Artist", 30)+field("Album", 30)+"1999"+field("", 28)+"\x00\x03\x00")
+
+	var buf bytes.Buffer
+	if err := DescribeFile(&buf, path, "/library", map[string]string{" ": "_"}, PathOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"artist:        Artist\n",
+		"track:         3/0\n",
+		"target:        " + filepath.Join("/library", "artist-album", "03-title.mp3") + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("DescribeFile() = %q, want it to contain %q", buf.String(), want)
+		}
+	}
+}
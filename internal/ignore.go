@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+// IgnoreFileName is the name of the per-directory ignore file read by
+// IgnoreFiles.
+const IgnoreFileName = ".musictaggerignore"
+
+// IgnoreFiles returns a Filter that honours ignore files named name in root,
+// the directory being scanned, and below it. Every line of such a file is a
+// filepath.Match pattern like the ones of musictagger.IgnorePatterns, and it
+// rejects matching files and directories in the ignore file's directory and
+// all of its descendants. Blank lines and lines starting with "#" are skipped.
+// The ignore files themselves are rejected too, so they never move with an
+// album.
+func IgnoreFiles(root, name string) musictagger.Filter {
+	root = filepath.Clean(root)
+	var mu sync.Mutex
+	patterns := map[string][]string{}
+
+	// load returns the patterns of the ignore file in dir, reading it only
+	// the first time
+	load := func(dir string) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		if p, ok := patterns[dir]; ok {
+			return p
+		}
+		p, err := readIgnoreFile(filepath.Join(dir, name))
+		if err != nil && !os.IsNotExist(err) {
+			log.Warn(err)
+		}
+		patterns[dir] = p
+		return p
+	}
+
+	return func(path string, d fs.DirEntry) bool {
+		if d.Name() == name {
+			return false
+		}
+		// ignore files above root, say a stray one in $HOME, don't count
+		for dir := filepath.Dir(path); within(root, dir); dir = filepath.Dir(dir) {
+			for _, p := range load(dir) {
+				if ok, _ := filepath.Match(p, d.Name()); ok {
+					return false
+				}
+			}
+			if dir == root {
+				break
+			}
+		}
+		return true
+	}
+}
+
+// within reports whether dir is root or one of its descendants.
+func within(root, dir string) bool {
+	rel, err := filepath.Rel(root, dir)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func readIgnoreFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIgnoreFiles(t *testing.T) {
+	// an ignore file above the scanned directory has no say
+	parent := t.TempDir()
+	writeTestFile(t, filepath.Join(parent, IgnoreFileName), "*\n")
+	root := filepath.Join(parent, "source")
+	for _, dir := range []string{filepath.Join("album", "nested"), "staging", "other"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(root, "album", IgnoreFileName), "# leftovers\n\n*.tmp\n")
+	writeTestFile(t, filepath.Join(root, "staging", IgnoreFileName), "*\n")
+	for _, file := range []string{
+		filepath.Join("album", "track.flac"),
+		filepath.Join("album", "track.tmp"),
+		filepath.Join("album", "nested", "cover.jpg"),
+		filepath.Join("album", "nested", "cover.tmp"),
+		filepath.Join("staging", "track.flac"),
+		filepath.Join("other", "track.tmp"),
+	} {
+		writeTestFile(t, filepath.Join(root, file), "content")
+	}
+
+	filter := IgnoreFiles(root, IgnoreFileName)
+	var got []string
+	if err := filepath.WalkDir(root, func(s string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !filter(s, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			rel, _ := filepath.Rel(root, s)
+			got = append(got, rel)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		filepath.Join("album", "nested", "cover.jpg"),
+		filepath.Join("album", "track.flac"),
+		filepath.Join("other", "track.tmp"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files let through = %v, want %v", got, want)
+	}
+}
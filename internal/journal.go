@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JournalEntry records a single successful move.
+type JournalEntry struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Mode is how the file was placed. Journals written before it was
+	// recorded only hold moves, so it's empty for those.
+	Mode      Mode      `json:"mode,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Journal appends moves to a JSON lines file so that they can be undone later.
+// It is safe for concurrent use.
+type Journal struct {
+	mode Mode
+
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// OpenJournal opens the journal at path for appending, creating it if needed.
+// Files are recorded as placed with mode.
+func OpenJournal(path string, mode Mode) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{mode: mode, f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record appends a move of from to to. Paths are stored as absolute paths so
+// the journal can be replayed from any directory. A nil journal records
+// nothing.
+func (j *Journal) Record(from, to string) error {
+	if j == nil {
+		return nil
+	}
+
+	from, err := filepath.Abs(from)
+	if err != nil {
+		return err
+	}
+	to, err = filepath.Abs(to)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.enc.Encode(JournalEntry{From: from, To: to, Mode: j.mode, Timestamp: time.Now()})
+}
+
+// Close closes the underlying file.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.f.Close()
+}
@@ -7,18 +7,20 @@ import (
 var _ tag.Metadata = (*mockTag)(nil)
 
 type mockTag struct {
-	album  string
-	artist string
-	track  int
-	tracks int
-	title  string
-	disc   int
-	discs  int
+	album       string
+	artist      string
+	albumArtist string
+	track       int
+	tracks      int
+	title       string
+	disc        int
+	discs       int
+	raw         map[string]interface{}
 }
 
 func (mockTag) Format() tag.Format            { return "" }
 func (mockTag) FileType() tag.FileType        { return tag.FLAC }
-func (m mockTag) Raw() map[string]interface{} { return nil }
+func (m mockTag) Raw() map[string]interface{} { return m.raw }
 
 func (m mockTag) Title() string         { return m.title }
 func (m mockTag) Album() string         { return m.album }
@@ -26,7 +28,7 @@ func (m mockTag) Artist() string        { return m.artist }
 func (m mockTag) Genre() string         { return "" }
 func (m mockTag) Year() int             { return 2024 }
 func (m mockTag) Track() (int, int)     { return m.track, m.tracks }
-func (m mockTag) AlbumArtist() string   { return "" }
+func (m mockTag) AlbumArtist() string   { return m.albumArtist }
 func (m mockTag) Composer() string      { return "" }
 func (m mockTag) Disc() (int, int)      { return m.disc, m.discs }
 func (m mockTag) Picture() *tag.Picture { return nil }
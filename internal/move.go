@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Mode controls how files are placed in the music library.
+type Mode string
+
+const (
+	// ModeMove renames files into the library.
+	ModeMove Mode = "move"
+	// ModeCopy copies files into the library, leaving the source in place.
+	ModeCopy Mode = "copy"
+	// ModeHardlink hard links files into the library, falling back to a
+	// symlink across filesystems.
+	ModeHardlink Mode = "hardlink"
+	// ModeSymlink symlinks files into the library.
+	ModeSymlink Mode = "symlink"
+)
+
+// Modes lists all valid modes.
+var Modes = []Mode{ModeMove, ModeCopy, ModeHardlink, ModeSymlink}
+
+// FileMover moves files into place. OSMover is the one used outside of
+// tests.
+type FileMover interface {
+	Move(src, dst string) error
+	MkdirAll(path string, perm os.FileMode) error
+}
+
+// OSMover moves files on the local filesystem according to Mode, retrying
+// transient failures up to Retries times.
+type OSMover struct {
+	Mode    Mode
+	Retries int
+}
+
+func (m OSMover) Move(src, dst string) error {
+	return MoveFileWithRetry(src, dst, m.Mode, m.Retries)
+}
+
+func (OSMover) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// rename and link are swapped out in tests to simulate cross-device failures.
+var (
+	rename = os.Rename
+	link   = os.Link
+)
+
+// MoveFile places src at dst according to mode. In ModeMove the file is
+// renamed, falling back to copy-and-delete when src and dst are on different
+// filesystems, and renamed in two steps when only the case of its name
+// changes. In every other mode the source is left untouched.
+func MoveFile(src, dst string, mode Mode) error {
+	switch mode {
+	case ModeCopy:
+		return copyFile(src, dst)
+	case ModeSymlink:
+		return replace(src, dst, func(tmp string) error { return symlink(src, tmp) })
+	case ModeHardlink:
+		return replace(src, dst, func(tmp string) error {
+			err := link(src, tmp)
+			if errors.Is(err, syscall.EXDEV) {
+				log.Warnf("cannot hard link %s across filesystems, symlinking it instead", src)
+				return symlink(src, tmp)
+			}
+			return err
+		})
+	}
+
+	if src != dst && strings.EqualFold(src, dst) {
+		return renameCase(src, dst)
+	}
+
+	err := rename(src, dst)
+	if errors.Is(err, syscall.EXDEV) {
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+		return os.Remove(src)
+	}
+	return err
+}
+
+// renameCase renames src to dst, which differs from it only by case, through
+// a temporary name. On a case-insensitive filesystem they're the same file,
+// and renaming it onto itself may do nothing or fail.
+func renameCase(src, dst string) error {
+	tmp := dst + ".musictagger-tmp"
+	if err := rename(src, tmp); err != nil {
+		return err
+	}
+	if err := rename(tmp, dst); err != nil {
+		// put it back rather than leave it under the temporary name
+		if err := rename(tmp, src); err != nil {
+			log.Warnf("%s was left at %s: %v", src, tmp, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// replace creates dst with create, which unlike a rename or a copy can't
+// overwrite a file that's already there, so the file is created under a
+// temporary name next to dst and then renamed over it. It refuses to replace
+// src itself, which would leave a link to nothing.
+func replace(src, dst string, create func(tmp string) error) error {
+	if err := distinct(src, dst, os.Lstat); err != nil {
+		return err
+	}
+	tmp := dst + ".musictagger-tmp"
+	if err := create(tmp); err != nil {
+		return err
+	}
+	if err := rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// renaming a hard link onto another link to the same file does nothing
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// distinct returns an error if dst, as described by stat, is the file src,
+// as it is when they only differ by case on a case-insensitive filesystem.
+// Copying or linking a file onto itself destroys it.
+func distinct(src, dst string, stat func(string) (fs.FileInfo, error)) error {
+	si, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	di, err := stat(dst)
+	if err != nil {
+		// nothing there to destroy
+		return nil
+	}
+	if os.SameFile(si, di) {
+		return fmt.Errorf("%s and %s are the same file", src, dst)
+	}
+	return nil
+}
+
+// retryBackoff is the delay before the first retry of a failed move. It
+// doubles with every further attempt.
+var retryBackoff = 100 * time.Millisecond
+
+// MoveFileWithRetry is MoveFile, retried up to retries times with
+// exponential backoff when it fails with an error that looks transient.
+func MoveFileWithRetry(src, dst string, mode Mode, retries int) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err := MoveFile(src, dst, mode)
+		if err == nil || attempt >= retries || !isTransient(err) {
+			return err
+		}
+
+		log.Debugf("moving %s failed: %v, retrying in %v", src, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// isTransient reports whether err is worth retrying, as opposed to e.g. a
+// missing file or a permission problem.
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EBUSY)
+}
+
+// symlink creates dst as a symlink to the absolute path of src, so the link
+// keeps working wherever the library is accessed from.
+func symlink(src, dst string) error {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(abs, dst)
+}
+
+// copyFile copies the contents of src to dst, preserving its mode and
+// modification time.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	// opening dst truncates it, so it had better not be src, also not
+	// through a symlink
+	if err := distinct(src, dst, os.Stat); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// the umask may have stripped some bits when the file was created
+	if err := os.Chmod(dst, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0640); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMoveFileLinks(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        Mode
+		crossDev    bool
+		wantSymlink bool
+	}{
+		{"hardlink", ModeHardlink, false, false},
+		{"hardlink across devices", ModeHardlink, true, true},
+		{"symlink", ModeSymlink, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src.flac")
+			dst := filepath.Join(dir, "dst.flac")
+			writeTestFile(t, src, "music")
+
+			if tt.crossDev {
+				link = func(string, string) error {
+					return &os.LinkError{Op: "link", Err: syscall.EXDEV}
+				}
+				defer func() { link = os.Link }()
+			}
+
+			if err := MoveFile(src, dst, tt.mode); err != nil {
+				t.Fatalf("MoveFile() error = %v", err)
+			}
+
+			if _, err := os.Stat(src); err != nil {
+				t.Errorf("source is gone: %v", err)
+			}
+
+			fi, err := os.Lstat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isSymlink := fi.Mode()&os.ModeSymlink != 0; isSymlink != tt.wantSymlink {
+				t.Errorf("target is symlink = %v, want %v", isSymlink, tt.wantSymlink)
+			}
+
+			b, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "music" {
+				t.Errorf("target content = %q, want %q", b, "music")
+			}
+		})
+	}
+}
+
+func TestMoveFileOverwrite(t *testing.T) {
+	for _, mode := range Modes {
+		t.Run(string(mode), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src.flac")
+			dst := filepath.Join(dir, "dst.flac")
+			writeTestFile(t, src, "music")
+			writeTestFile(t, dst, "old")
+
+			if err := MoveFile(src, dst, mode); err != nil {
+				t.Fatalf("MoveFile() error = %v", err)
+			}
+
+			b, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "music" {
+				t.Errorf("target content = %q, want %q", b, "music")
+			}
+			if _, err := os.Lstat(dst + ".musictagger-tmp"); err == nil {
+				t.Errorf("temporary file was left behind")
+			}
+		})
+	}
+}
+
+func TestMoveFileOntoItself(t *testing.T) {
+	for _, mode := range []Mode{ModeCopy, ModeHardlink, ModeSymlink} {
+		t.Run(string(mode), func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "track.flac")
+			// the same file under another name, as a case-only change is on a
+			// case-insensitive filesystem
+			dst := filepath.Join(dir, "Track.flac")
+			writeTestFile(t, src, "music")
+			if err := os.Link(src, dst); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := MoveFile(src, dst, mode); err == nil {
+				t.Errorf("MoveFile() succeeded, want an error")
+			}
+
+			for _, path := range []string{src, dst} {
+				b, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != "music" {
+					t.Errorf("%s content = %q, want %q", path, b, "music")
+				}
+			}
+		})
+	}
+}
+
+func TestMoveFile(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		mode       Mode
+		crossDev   bool
+		wantSource bool
+	}{
+		{"move", ModeMove, false, false},
+		{"move across devices", ModeMove, true, false},
+		{"copy", ModeCopy, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src.flac")
+			dst := filepath.Join(dir, "dst.flac")
+			writeTestFile(t, src, "music")
+			if err := os.Chtimes(src, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.crossDev {
+				rename = func(string, string) error {
+					return &os.LinkError{Op: "rename", Err: syscall.EXDEV}
+				}
+				defer func() { rename = os.Rename }()
+			}
+
+			if err := MoveFile(src, dst, tt.mode); err != nil {
+				t.Fatalf("MoveFile() error = %v", err)
+			}
+
+			if _, err := os.Stat(src); (err == nil) != tt.wantSource {
+				t.Errorf("source exists = %v, want %v", err == nil, tt.wantSource)
+			}
+
+			b, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "music" {
+				t.Errorf("target content = %q, want %q", b, "music")
+			}
+
+			fi, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fi.ModTime().Equal(mtime) {
+				t.Errorf("target mtime = %v, want %v", fi.ModTime(), mtime)
+			}
+		})
+	}
+}
+
+func TestMoveFileWithRetry(t *testing.T) {
+	retryBackoff = time.Millisecond
+	defer func() { retryBackoff = 100 * time.Millisecond }()
+
+	tests := []struct {
+		name     string
+		failures []error
+		retries  int
+		wantErr  bool
+		wantCall int
+	}{
+		{"transient failures then success", []error{syscall.EAGAIN, syscall.EBUSY}, 3, false, 3},
+		{"out of retries", []error{syscall.EAGAIN, syscall.EAGAIN}, 1, true, 2},
+		{"permanent failure", []error{syscall.EACCES}, 3, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src.flac")
+			dst := filepath.Join(dir, "dst.flac")
+			writeTestFile(t, src, "music")
+
+			calls := 0
+			rename = func(oldpath, newpath string) error {
+				calls++
+				if calls <= len(tt.failures) {
+					return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: tt.failures[calls-1]}
+				}
+				return os.Rename(oldpath, newpath)
+			}
+			defer func() { rename = os.Rename }()
+
+			err := MoveFileWithRetry(src, dst, ModeMove, tt.retries)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MoveFileWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCall {
+				t.Errorf("rename called %d times, want %d", calls, tt.wantCall)
+			}
+		})
+	}
+}
+
+func TestMoveFileCaseOnly(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "Track.flac")
+	dst := filepath.Join(dir, "track.flac")
+	writeTestFile(t, src, "music")
+
+	var calls [][2]string
+	rename = func(oldpath, newpath string) error {
+		calls = append(calls, [2]string{oldpath, newpath})
+		return os.Rename(oldpath, newpath)
+	}
+	defer func() { rename = os.Rename }()
+
+	if err := MoveFile(src, dst, ModeMove); err != nil {
+		t.Fatalf("MoveFile() error = %v", err)
+	}
+
+	tmp := dst + ".musictagger-tmp"
+	if want := [][2]string{{src, tmp}, {tmp, dst}}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("renames = %v, want %v", calls, want)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "track.flac" {
+		t.Errorf("directory holds %v, want only track.flac", entries)
+	}
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"strings"
+	"unicode"
+)
+
+// diacritics maps letters carrying diacritics to their plain form. The
+// standard library can't decompose runes, so this covers the Latin-1 and
+// Latin Extended-A letters music tags actually use.
+var diacritics = map[rune]string{}
+
+func init() {
+	for plain, accented := range map[string]string{
+		"a": "àáâãäåāăą", "c": "çćĉċč", "d": "ďđ", "e": "èéêëēĕėęě",
+		"g": "ĝğġģ", "h": "ĥħ", "i": "ìíîïĩīĭįı", "j": "ĵ", "k": "ķ",
+		"l": "ĺļľŀł", "n": "ñńņňŉ", "o": "òóôõöøōŏő", "r": "ŕŗř",
+		"s": "śŝşš", "t": "ţťŧ", "u": "ùúûüũūŭůűų", "w": "ŵ", "y": "ýÿŷ",
+		"z": "źżž", "ae": "æ", "oe": "œ", "ss": "ß", "th": "þ",
+	} {
+		for _, r := range accented {
+			diacritics[r] = plain
+		}
+	}
+}
+
+// NormalizeName folds s into a canonical form for comparing names that are
+// spelled differently, e.g. "Motörhead" and "motorhead": lower case, without
+// diacritics, and with runs of whitespace collapsed into single spaces. It's
+// only meant for comparisons, never for names written to disk.
+func NormalizeName(s string) string {
+	var b strings.Builder
+	for _, word := range strings.Fields(s) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		for _, r := range word {
+			r = unicode.ToLower(r)
+			if plain, ok := diacritics[r]; ok {
+				b.WriteString(plain)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,24 @@
+package internal
+
+import "testing"
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Beyoncé", "beyonce"},
+		{"Motörhead", "motorhead"},
+		{"  Sigur   Rós\t", "sigur ros"},
+		{"Łódź Æther", "lodz aether"},
+		{"plain", "plain"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeName(tt.name); got != tt.want {
+				t.Errorf("NormalizeName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,633 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dhowden/tag"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+// ConflictPolicy decides what happens when a target path is already taken,
+// either by a file in the library or by another file in the same run.
+type ConflictPolicy string
+
+const (
+	// ConflictSkip leaves the source file where it is.
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictRename appends " (2)", " (3)", ... to the target file name.
+	ConflictRename ConflictPolicy = "rename"
+	// ConflictOverwrite replaces the existing target.
+	ConflictOverwrite ConflictPolicy = "overwrite"
+)
+
+// DefaultCompanionExtensions are the extensions of the non-music files that
+// follow an album into the library by default. WAV files are among them
+// because their tags can't be read, so they can only move with the album.
+var DefaultCompanionExtensions = []string{
+	"jpg", "jpeg", "png", "gif", "webp", "pdf", "txt", "cue", "log", "nfo", "m3u", "m3u8", "wav",
+}
+
+// RenamePlan is a single pending move of Source to Target.
+type RenamePlan struct {
+	Source string
+	Target string
+}
+
+// AlbumPlan describes how one source album directory maps into the library.
+type AlbumPlan struct {
+	// Source is the original album directory.
+	Source string
+	// Target is the album directory in the library.
+	Target string
+	// Music are the moves of the album's music files. Files that are already
+	// in place are left out.
+	Music []RenamePlan
+	// Companions are the moves of any other files in the source directory.
+	Companions []RenamePlan
+	// Skipped are the files left where they are because of a conflict.
+	Skipped []string
+	// Tracks is the album's music as read from the source directory.
+	Tracks []musictagger.Music
+	// Flat is set when the album has no directory of its own in the library.
+	Flat bool
+	// Placed maps the source path of every track to where it ends up,
+	// including tracks that are already in place. Tracks that are skipped
+	// because of a conflict are left out.
+	Placed map[string]string
+}
+
+// Moves returns all of the album's moves, music first.
+func (a AlbumPlan) Moves() []RenamePlan {
+	return append(append([]RenamePlan{}, a.Music...), a.Companions...)
+}
+
+// Planner computes where files should go in the music library without
+// touching the filesystem.
+type Planner struct {
+	Library      string
+	Replacements map[string]string
+	PathOptions  PathOptions
+	OnConflict   ConflictPolicy
+
+	// Compilations files albums detected by IsCompilation under
+	// VariousArtists instead of each track's own artist.
+	Compilations bool
+
+	// CompanionExtensions limits which non-music files follow the music to
+	// these extensions. When empty, all of them do.
+	CompanionExtensions []string
+
+	// Filters decide which companion files follow the music. They should
+	// match the filters the library was scanned with.
+	Filters []musictagger.Filter
+
+	// GroupAlbums puts all tracks of a source directory in the one album
+	// directory most of them compute to, so that a track with a stray
+	// artist or album spelling doesn't split the album. Without it every
+	// track's directory comes from its own tags.
+	GroupAlbums bool
+
+	// NormalizeNames makes GroupAlbums treat directory names that only
+	// differ in diacritics or whitespace, as NormalizeName sees them, as
+	// the same album.
+	NormalizeNames bool
+
+	// RequireFields lists tags, out of RequiredFields, that a file must
+	// have to be moved. Files missing any of them are left where they are
+	// instead of being filed under a half-empty path such as "-/00-title".
+	RequireFields []string
+
+	// MaxAlbums, if positive, stops Plan after that many albums. It doesn't
+	// limit PlanNonMusic.
+	MaxAlbums int
+
+	// ModifiedSince, if set, leaves music, and files PlanNonMusic would
+	// move, modified before it where they are.
+	// It's applied here rather than to the scan, so directories whose music
+	// is all older still count as music directories for PlanNonMusic.
+	ModifiedSince time.Time
+
+	// Mode is how files will be placed, ModeMove if empty. Only a move can
+	// change the case of a file's name, so in other modes a target that's
+	// the source itself under another case, as on a case-insensitive
+	// filesystem, counts as already in place.
+	Mode Mode
+
+	// claimed holds the targets handed out so far, so that two sources of
+	// the same run don't end up on the same path.
+	claimed map[string]bool
+}
+
+// PlanAlbum computes the moves for the music found in dir.
+func (p *Planner) PlanAlbum(dir string, music []musictagger.Music) (AlbumPlan, error) {
+	plan := AlbumPlan{
+		Source: dir,
+		Target: p.Library,
+		Tracks: music,
+		Flat:   p.PathOptions.Flat,
+		Placed: map[string]string{},
+	}
+
+	compilation := p.Compilations && IsCompilation(music)
+
+	opts := p.PathOptions
+	if opts.DynamicTrackPadding {
+		opts.TrackWidth = trackWidth(music, opts.MissingTrack)
+	}
+
+	// untracked files are numbered after the highest track number
+	var lastTrack int
+	if p.PathOptions.MissingTrack == MissingTrackIndex {
+		for _, m := range music {
+			if track, _ := m.Metadata.Track(); track > lastTrack {
+				lastTrack = track
+			}
+		}
+	}
+
+	isMusic := map[string]bool{}
+	var sources, targets []string
+	for _, m := range music {
+		isMusic[m.Path] = true
+
+		if p.unmodified(m.Path) {
+			continue
+		}
+
+		if missing := missingFields(m.Metadata, p.RequireFields); len(missing) > 0 {
+			log.WithFields(log.Fields{"path": m.Path, "action": "skip"}).
+				Warnf("%s has no %s tag, skipping it", m.Path, strings.Join(missing, " or "))
+			plan.Skipped = append(plan.Skipped, m.Path)
+			continue
+		}
+
+		metadata := m.Metadata
+		if compilation {
+			metadata = compilationTag{metadata}
+		}
+		if track, _ := metadata.Track(); track == 0 && p.PathOptions.MissingTrack == MissingTrackIndex {
+			lastTrack++
+			metadata = numberedTag{metadata, lastTrack}
+		}
+
+		sources = append(sources, m.Path)
+		targets = append(targets, filepath.Join(p.Library, ComputeTargetPath(metadata, m.Path, p.Replacements, opts)))
+	}
+
+	if p.GroupAlbums && !plan.Flat {
+		dir := majorityDir(targets, p.NormalizeNames)
+		for i, target := range targets {
+			targets[i] = filepath.Join(dir, filepath.Base(target))
+		}
+	}
+
+	for i, target := range targets {
+		source := sources[i]
+		plan.Target = filepath.Dir(target)
+		if p.inPlace(source, target) {
+			p.claim(target)
+			plan.Placed[source] = source
+			continue
+		}
+		if target, ok := p.resolve(source, target); ok {
+			plan.Music = append(plan.Music, RenamePlan{source, target})
+			plan.Placed[source] = target
+		} else {
+			plan.Skipped = append(plan.Skipped, source)
+		}
+	}
+
+	// in flat mode there's no album directory for other files to go to, and
+	// neither is there when every track was left behind
+	if plan.Flat || len(targets) == 0 || plan.Source == plan.Target {
+		return plan, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return plan, err
+	}
+	for _, e := range entries {
+		source := filepath.Join(dir, e.Name())
+		if e.IsDir() || isMusic[source] || !musictagger.Accept(p.Filters, source, e) {
+			continue
+		}
+		if len(p.CompanionExtensions) > 0 && !musictagger.HasExtension(source, p.CompanionExtensions) {
+			continue
+		}
+		target := filepath.Join(plan.Target, e.Name())
+		if p.inPlace(source, target) {
+			p.claim(target)
+			continue
+		}
+		if target, ok := p.resolve(source, target); ok {
+			plan.Companions = append(plan.Companions, RenamePlan{source, target})
+		} else {
+			plan.Skipped = append(plan.Skipped, source)
+		}
+	}
+
+	return plan, nil
+}
+
+// majorityDir returns the directory most of targets are in. On a tie, the
+// one that reached that count first wins. With normalize, directories whose
+// names only differ by NormalizeName count as one, and the spelling most of
+// them use is returned.
+func majorityDir(targets []string, normalize bool) string {
+	key := func(dir string) string {
+		if normalize {
+			return NormalizeName(dir)
+		}
+		return dir
+	}
+
+	groups, spellings := map[string]int{}, map[string]int{}
+	var best string
+	for _, target := range targets {
+		k := key(filepath.Dir(target))
+		groups[k]++
+		if groups[k] > groups[best] {
+			best = k
+		}
+	}
+
+	var dir string
+	for _, target := range targets {
+		if d := filepath.Dir(target); key(d) == best {
+			spellings[d]++
+			if spellings[d] > spellings[dir] {
+				dir = d
+			}
+		}
+	}
+	return dir
+}
+
+// trackWidth returns the number of digits needed for the largest track
+// number or track total of music, counting the numbers strategy hands out to
+// untracked files.
+func trackWidth(music []musictagger.Music, strategy MissingTrackStrategy) int {
+	var highest, total, untracked int
+	for _, m := range music {
+		track, tracks := m.Metadata.Track()
+		highest = max(highest, track)
+		total = max(total, tracks)
+		if track == 0 {
+			untracked++
+		}
+	}
+	if strategy == MissingTrackIndex {
+		highest += untracked
+	}
+	return len(strconv.Itoa(max(highest, total)))
+}
+
+func (p *Planner) claim(target string) {
+	if p.claimed == nil {
+		p.claimed = map[string]bool{}
+	}
+	p.claimed[target] = true
+}
+
+func (p *Planner) taken(target string) bool {
+	if p.claimed[target] {
+		return true
+	}
+	_, err := os.Lstat(target)
+	return err == nil
+}
+
+// sameFile reports whether source and target, which differ only by case if
+// at all, are the same file, as they are on a case-insensitive filesystem.
+// Then target isn't a conflict, just a new name for source.
+func sameFile(source, target string) bool {
+	if !strings.EqualFold(source, target) {
+		return false
+	}
+	si, err := os.Lstat(source)
+	if err != nil {
+		return false
+	}
+	ti, err := os.Lstat(target)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(si, ti)
+}
+
+// unmodified reports whether path was last modified before ModifiedSince.
+func (p *Planner) unmodified(path string) bool {
+	if p.ModifiedSince.IsZero() {
+		return false
+	}
+	fi, err := os.Stat(path)
+	return err == nil && fi.ModTime().Before(p.ModifiedSince)
+}
+
+// inPlace reports whether source is already at target, so that there's
+// nothing to place.
+func (p *Planner) inPlace(source, target string) bool {
+	if source == target {
+		return true
+	}
+	return p.Mode != "" && p.Mode != ModeMove && sameFile(source, target)
+}
+
+// sameContent reports whether the files a and b have the same content.
+func sameContent(a, b string) (bool, error) {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if ai.Size() != bi.Size() {
+		return false, nil
+	}
+
+	ah, err := hashFile(a)
+	if err != nil {
+		return false, err
+	}
+	bh, err := hashFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(ah, bh), nil
+}
+
+func hashFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// resolve applies the conflict policy to target, returning the path source
+// should be moved to, or false if it should not be moved at all. Existing
+// targets identical to source are never conflicts, source is simply skipped.
+func (p *Planner) resolve(source, target string) (string, bool) {
+	if !p.taken(target) || sameFile(source, target) {
+		p.claim(target)
+		return target, true
+	}
+
+	logger := log.WithFields(log.Fields{"path": source, "target": target})
+
+	if p.imported(logger, source, target) {
+		return "", false
+	}
+	switch p.OnConflict {
+	case ConflictOverwrite:
+		logger.WithField("action", "overwrite").Warnf("%s already exists, overwriting it with %s", target, source)
+	case ConflictRename:
+		ext := filepath.Ext(target)
+		stem := strings.TrimSuffix(target, ext)
+		var renamed string
+		for i := 2; ; i++ {
+			renamed = fmt.Sprintf("%s (%d)%s", stem, i, ext)
+			if !p.taken(renamed) {
+				break
+			}
+			// an earlier run may have renamed the file too
+			if p.imported(logger, source, renamed) {
+				return "", false
+			}
+		}
+		logger.WithField("action", "rename").Warnf("%s already exists, moving %s to %s instead", target, source, renamed)
+		target = renamed
+	default:
+		logger.WithField("action", "skip").Warnf("%s already exists, skipping %s", target, source)
+		return "", false
+	}
+
+	p.claim(target)
+	return target, true
+}
+
+// imported reports whether the existing target holds the same content as
+// source and wasn't claimed in this run, meaning source was already imported
+// by an earlier run, which isn't a conflict.
+func (p *Planner) imported(logger *log.Entry, source, target string) bool {
+	if p.claimed[target] {
+		return false
+	}
+	same, err := sameContent(source, target)
+	if err != nil {
+		logger.Warn(err)
+	}
+	if same {
+		logger.WithField("action", "skip").Infof("%s is already in the library as %s, skipping it", source, target)
+	}
+	return same
+}
+
+// PlanNonMusic computes moves for the files under source that live in
+// directories without any music, i.e. directories that aren't keys of
+// library. They are moved to target, keeping their path relative to source.
+// Files modified before ModifiedSince stay, but MaxAlbums doesn't apply.
+func (p *Planner) PlanNonMusic(source string, library map[string][]musictagger.Music, target string) ([]RenamePlan, error) {
+	// the walk yields paths spelled after source, so compare absolute paths
+	// to recognise target and the library however they're given
+	skip := map[string]bool{}
+	for _, dir := range []string{target, p.Library} {
+		if dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		skip[abs] = true
+	}
+
+	var moves []RenamePlan
+	err := filepath.WalkDir(source, func(s string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if s == source {
+			return nil
+		}
+		if d.IsDir() {
+			abs, err := filepath.Abs(s)
+			if err != nil {
+				return err
+			}
+			if skip[abs] {
+				return filepath.SkipDir
+			}
+		}
+		if !musictagger.Accept(p.Filters, s, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if _, ok := library[filepath.Dir(s)]; ok || p.unmodified(s) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(source, s)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(target, rel)
+		if p.inPlace(s, dst) {
+			p.claim(dst)
+			return nil
+		}
+		if dst, ok := p.resolve(s, dst); ok {
+			moves = append(moves, RenamePlan{s, dst})
+		}
+		return nil
+	})
+	return moves, err
+}
+
+// AlbumsByTarget regroups the placed tracks of plans by the album directory
+// they're placed in, ordered by that directory. Tracks of several source
+// directories that go to one album directory end up in one plan, whose
+// Target is that directory. Flat plans are left out, since their tracks share
+// no album directory.
+func AlbumsByTarget(plans []AlbumPlan) []AlbumPlan {
+	byDir := map[string]*AlbumPlan{}
+	for _, plan := range plans {
+		if plan.Flat {
+			continue
+		}
+		for _, m := range plan.Tracks {
+			target, ok := plan.Placed[m.Path]
+			if !ok {
+				continue
+			}
+			dir := filepath.Dir(target)
+			album, ok := byDir[dir]
+			if !ok {
+				album = &AlbumPlan{Source: plan.Source, Target: dir, Placed: map[string]string{}}
+				byDir[dir] = album
+			}
+			album.Tracks = append(album.Tracks, m)
+			album.Placed[m.Path] = target
+		}
+	}
+
+	albums := make([]AlbumPlan, 0, len(byDir))
+	for _, album := range byDir {
+		albums = append(albums, *album)
+	}
+	sort.Slice(albums, func(i, j int) bool { return albums[i].Target < albums[j].Target })
+	return albums
+}
+
+// numberedTag gives a track without a track number one.
+type numberedTag struct {
+	tag.Metadata
+	track int
+}
+
+func (t numberedTag) Track() (int, int) {
+	_, total := t.Metadata.Track()
+	return t.track, total
+}
+
+// Plan computes the moves for a whole library as returned by
+// musictagger.GetAllTags, ordered by source directory and capped at
+// MaxAlbums.
+func (p *Planner) Plan(library map[string][]musictagger.Music) ([]AlbumPlan, error) {
+	dirs := make([]string, 0, len(library))
+	for dir := range library {
+		dirs = append(dirs, dir)
+	}
+	// plan in a stable order so it's predictable which source wins a conflict
+	sort.Strings(dirs)
+	if p.MaxAlbums > 0 && len(dirs) > p.MaxAlbums {
+		dirs = dirs[:p.MaxAlbums]
+	}
+
+	var plans []AlbumPlan
+	for _, dir := range dirs {
+		plan, err := p.PlanAlbum(dir, library[dir])
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// ForEach calls fn for every album plan using up to workers goroutines. A
+// single album is always handled by one goroutine, so its moves stay
+// sequential. Once ctx is cancelled no further albums are started, albums
+// already started are left to fn, and ctx.Err() is returned.
+func ForEach(ctx context.Context, plans []AlbumPlan, workers int, fn func(context.Context, AlbumPlan)) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	ch := make(chan AlbumPlan)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for plan := range ch {
+				fn(ctx, plan)
+			}
+		}()
+	}
+
+	defer func() {
+		close(ch)
+		wg.Wait()
+	}()
+	for _, plan := range plans {
+		select {
+		case ch <- plan:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// WritePlan writes the moves as an aligned table sorted by source.
+func WritePlan(w io.Writer, moves []RenamePlan) error {
+	sorted := append([]RenamePlan{}, moves...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Source < sorted[j].Source })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SOURCE\tTARGET")
+	for _, m := range sorted {
+		fmt.Fprintf(tw, "%s\t%s\n", m.Source, m.Target)
+	}
+	return tw.Flush()
+}
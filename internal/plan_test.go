@@ -0,0 +1,650 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+func TestPlannerPlanAlbum(t *testing.T) {
+	library := t.TempDir()
+	source := t.TempDir()
+	planner := Planner{Library: library}
+
+	track := mockTag{album: "album", artist: "artist", track: 1, title: "title"}
+	writeTestFile(t, filepath.Join(source, "track.flac"), "music")
+	writeTestFile(t, filepath.Join(source, "cover.jpg"), "image")
+
+	t.Run("moves music and companions", func(t *testing.T) {
+		music := []musictagger.Music{{Path: filepath.Join(source, "track.flac"), Metadata: track}}
+		got, err := planner.PlanAlbum(source, music)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []RenamePlan{
+			{filepath.Join(source, "track.flac"), filepath.Join(library, "artist-album", "01-title.flac")},
+			{filepath.Join(source, "cover.jpg"), filepath.Join(library, "artist-album", "cover.jpg")},
+		}
+		if !reflect.DeepEqual(got.Moves(), want) {
+			t.Errorf("PlanAlbum() moves = %v, want %v", got.Moves(), want)
+		}
+		if got.Target != filepath.Join(library, "artist-album") {
+			t.Errorf("PlanAlbum() target = %v, want %v", got.Target, filepath.Join(library, "artist-album"))
+		}
+	})
+
+	t.Run("already in place", func(t *testing.T) {
+		inPlace := filepath.Join(library, "artist-album")
+		music := []musictagger.Music{{Path: filepath.Join(inPlace, "01-title.flac"), Metadata: track}}
+		got, err := planner.PlanAlbum(inPlace, music)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Moves()) != 0 {
+			t.Errorf("PlanAlbum() moves = %v, want none", got.Moves())
+		}
+	})
+}
+
+func TestPlannerCompanionExtensions(t *testing.T) {
+	library := t.TempDir()
+	source := t.TempDir()
+	writeTestFile(t, filepath.Join(source, "track.flac"), "music")
+	writeTestFile(t, filepath.Join(source, "booklet.PDF"), "booklet")
+	writeTestFile(t, filepath.Join(source, ".DS_Store"), "junk")
+	writeTestFile(t, filepath.Join(source, "Thumbs.db"), "junk")
+
+	planner := Planner{Library: library, CompanionExtensions: DefaultCompanionExtensions}
+	plan, err := planner.PlanAlbum(source, []musictagger.Music{
+		{Path: filepath.Join(source, "track.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 1, title: "title"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RenamePlan{{filepath.Join(source, "booklet.PDF"), filepath.Join(library, "artist-album", "booklet.PDF")}}
+	if !reflect.DeepEqual(plan.Companions, want) {
+		t.Errorf("PlanAlbum() companions = %v, want %v", plan.Companions, want)
+	}
+}
+
+func TestPlannerMissingTrack(t *testing.T) {
+	tests := []struct {
+		strategy MissingTrackStrategy
+		want     []string
+	}{
+		{"", []string{"00-one.flac", "00-two.flac", "01-three.flac"}},
+		{MissingTrackZero, []string{"00-one.flac", "00-two.flac", "01-three.flac"}},
+		{MissingTrackIndex, []string{"02-one.flac", "03-two.flac", "01-three.flac"}},
+		{MissingTrackFilename, []string{"a.flac", "b.flac", "01-three.flac"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			library := t.TempDir()
+			source := t.TempDir()
+
+			planner := Planner{Library: library, PathOptions: PathOptions{MissingTrack: tt.strategy}}
+			plan, err := planner.PlanAlbum(source, []musictagger.Music{
+				{Path: filepath.Join(source, "A.flac"), Metadata: mockTag{album: "album", artist: "artist", title: "one"}},
+				{Path: filepath.Join(source, "b.flac"), Metadata: mockTag{album: "album", artist: "artist", title: "two"}},
+				{Path: filepath.Join(source, "c.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 1, title: "three"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, m := range plan.Music {
+				got = append(got, filepath.Base(m.Target))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planned files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlannerGroupAlbums(t *testing.T) {
+	music := []musictagger.Music{
+		{Path: "/src/album/a.flac", Metadata: mockTag{album: "album", albumArtist: "artist", track: 1, title: "a"}},
+		{Path: "/src/album/b.flac", Metadata: mockTag{album: "album", albumArtist: "artst", track: 2, title: "b"}},
+		{Path: "/src/album/c.flac", Metadata: mockTag{album: "album", albumArtist: "artist", track: 3, title: "c"}},
+	}
+
+	// without the replacements table, spellings with diacritics stay apart
+	accented := []musictagger.Music{
+		{Path: "/src/album/a.flac", Metadata: mockTag{album: "album", albumArtist: "Motörhead", track: 1, title: "a"}},
+		{Path: "/src/album/b.flac", Metadata: mockTag{album: "album", albumArtist: "Motorhead", track: 2, title: "b"}},
+		{Path: "/src/album/c.flac", Metadata: mockTag{album: "album", albumArtist: "Motörhead", track: 3, title: "c"}},
+		{Path: "/src/album/d.flac", Metadata: mockTag{album: "other", albumArtist: "Motorhead", track: 4, title: "d"}},
+		{Path: "/src/album/e.flac", Metadata: mockTag{album: "other", albumArtist: "Motorhead", track: 5, title: "e"}},
+		{Path: "/src/album/f.flac", Metadata: mockTag{album: "other", albumArtist: "Motorhead", track: 6, title: "f"}},
+	}
+
+	tests := []struct {
+		name      string
+		music     []musictagger.Music
+		group     bool
+		normalize bool
+		want      []string
+	}{
+		{"per file", music, false, false, []string{
+			filepath.Join("artist-album", "01-a.flac"),
+			filepath.Join("artst-album", "02-b.flac"),
+			filepath.Join("artist-album", "03-c.flac"),
+		}},
+		{"grouped", music, true, false, []string{
+			filepath.Join("artist-album", "01-a.flac"),
+			filepath.Join("artist-album", "02-b.flac"),
+			filepath.Join("artist-album", "03-c.flac"),
+		}},
+		{"grouped by raw name", accented, true, false, []string{
+			filepath.Join("motorhead-other", "01-a.flac"),
+			filepath.Join("motorhead-other", "02-b.flac"),
+			filepath.Join("motorhead-other", "03-c.flac"),
+			filepath.Join("motorhead-other", "04-d.flac"),
+			filepath.Join("motorhead-other", "05-e.flac"),
+			filepath.Join("motorhead-other", "06-f.flac"),
+		}},
+		{"grouped by normalized name", accented, true, true, []string{
+			filepath.Join("motörhead-album", "01-a.flac"),
+			filepath.Join("motörhead-album", "02-b.flac"),
+			filepath.Join("motörhead-album", "03-c.flac"),
+			filepath.Join("motörhead-album", "04-d.flac"),
+			filepath.Join("motörhead-album", "05-e.flac"),
+			filepath.Join("motörhead-album", "06-f.flac"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			library := t.TempDir()
+			planner := Planner{Library: library, GroupAlbums: tt.group, NormalizeNames: tt.normalize}
+			// the source directory is empty, so there are no companions
+			plan, err := planner.PlanAlbum(t.TempDir(), tt.music)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, m := range plan.Music {
+				rel, _ := filepath.Rel(library, m.Target)
+				got = append(got, rel)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planned targets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlannerDynamicTrackPadding(t *testing.T) {
+	tests := []struct {
+		name   string
+		tracks []mockTag
+		want   string
+	}{
+		{"ep", []mockTag{{track: 3, tracks: 3}}, "03-title.flac"},
+		{"large total", []mockTag{{track: 7, tracks: 120}}, "007-title.flac"},
+		{"large track", []mockTag{{track: 7}, {track: 120}}, "007-title.flac"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := t.TempDir()
+			var music []musictagger.Music
+			for i, track := range tt.tracks {
+				track.album, track.artist, track.title = "album", "artist", "title"
+				music = append(music, musictagger.Music{Path: filepath.Join(source, fmt.Sprintf("%d.flac", i)), Metadata: track})
+			}
+
+			planner := Planner{Library: t.TempDir(), PathOptions: PathOptions{DynamicTrackPadding: true}}
+			plan, err := planner.PlanAlbum(source, music)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filepath.Base(plan.Music[0].Target); got != tt.want {
+				t.Errorf("first track = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlannerRequireFields(t *testing.T) {
+	library := t.TempDir()
+	source := t.TempDir()
+	writeTestFile(t, filepath.Join(source, "cover.jpg"), "image")
+
+	planner := Planner{Library: library, RequireFields: []string{"artist", "album"}}
+
+	t.Run("fully tagged", func(t *testing.T) {
+		plan, err := planner.PlanAlbum(source, []musictagger.Music{
+			{Path: filepath.Join(source, "a.flac"), Metadata: mockTag{album: "album", albumArtist: "artist", title: "a"}},
+			{Path: filepath.Join(source, "b.flac"), Metadata: mockTag{artist: "artist", title: "b"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []RenamePlan{
+			{filepath.Join(source, "a.flac"), filepath.Join(library, "artist-album", "00-a.flac")},
+			{filepath.Join(source, "cover.jpg"), filepath.Join(library, "artist-album", "cover.jpg")},
+		}
+		if !reflect.DeepEqual(plan.Moves(), want) {
+			t.Errorf("PlanAlbum() moves = %v, want %v", plan.Moves(), want)
+		}
+		if want := []string{filepath.Join(source, "b.flac")}; !reflect.DeepEqual(plan.Skipped, want) {
+			t.Errorf("PlanAlbum() skipped = %v, want %v", plan.Skipped, want)
+		}
+	})
+
+	t.Run("missing album", func(t *testing.T) {
+		plan, err := planner.PlanAlbum(source, []musictagger.Music{
+			{Path: filepath.Join(source, "a.flac"), Metadata: mockTag{artist: "artist", title: "a"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the cover stays with the track
+		if len(plan.Moves()) != 0 {
+			t.Errorf("PlanAlbum() moves = %v, want none", plan.Moves())
+		}
+	})
+}
+
+func TestPlannerPlanNonMusic(t *testing.T) {
+	source := t.TempDir()
+	target := filepath.Join(t.TempDir(), "extras")
+	library := map[string][]musictagger.Music{
+		filepath.Join(source, "album"): {{Path: filepath.Join(source, "album", "track.flac"), Metadata: mockTag{}}},
+	}
+
+	for _, dir := range []string{"album", "scans", filepath.Join("scans", "nested")} {
+		if err := os.MkdirAll(filepath.Join(source, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(source, "album", "track.flac"), "music")
+	writeTestFile(t, filepath.Join(source, "album", "cover.jpg"), "cover")
+	writeTestFile(t, filepath.Join(source, "scans", "front.jpg"), "front")
+	writeTestFile(t, filepath.Join(source, "scans", "nested", "back.jpg"), "back")
+
+	planner := Planner{Library: t.TempDir()}
+	got, err := planner.PlanNonMusic(source, library, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RenamePlan{
+		{filepath.Join(source, "scans", "front.jpg"), filepath.Join(target, "scans", "front.jpg")},
+		{filepath.Join(source, "scans", "nested", "back.jpg"), filepath.Join(target, "scans", "nested", "back.jpg")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanNonMusic() = %v, want %v", got, want)
+	}
+}
+
+func TestPlannerCaseOnlyTarget(t *testing.T) {
+	// a hard link under a name that only differs by case stands in for the
+	// source itself on a case-insensitive filesystem
+	root := t.TempDir()
+	source := filepath.Join(root, "scans")
+	target := filepath.Join(root, "Scans")
+	for _, dir := range []string{source, target} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(source, "front.jpg"), "front")
+	if err := os.Link(filepath.Join(source, "front.jpg"), filepath.Join(target, "front.jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		mode Mode
+		want []RenamePlan
+	}{
+		{ModeMove, []RenamePlan{{filepath.Join(source, "front.jpg"), filepath.Join(target, "front.jpg")}}},
+		{ModeCopy, nil},
+		{ModeSymlink, nil},
+	} {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			planner := Planner{Library: t.TempDir(), Mode: tt.mode}
+			got, err := planner.PlanNonMusic(source, nil, target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanNonMusic() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlannerPlanNonMusicRelative(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, dir := range []string{"scans", filepath.Join("extras", "scans"), filepath.Join("lib", "artist-album")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join("scans", "b.jpg"), "new")
+	writeTestFile(t, filepath.Join("extras", "scans", "a.jpg"), "from an earlier run")
+	writeTestFile(t, filepath.Join("lib", "artist-album", "cover.jpg"), "cover")
+
+	planner := Planner{Library: "./lib"}
+	got, err := planner.PlanNonMusic(".", nil, "./extras")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RenamePlan{{filepath.Join("scans", "b.jpg"), filepath.Join("extras", "scans", "b.jpg")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PlanNonMusic() = %v, want %v", got, want)
+	}
+}
+
+func TestWritePlan(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WritePlan(&buf, []RenamePlan{
+		{"/src/b.flac", "/lib/b.flac"},
+		{"/src/a-long-name.flac", "/lib/a.flac"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"SOURCE                 TARGET",
+		"/src/a-long-name.flac  /lib/a.flac",
+		"/src/b.flac            /lib/b.flac",
+		"",
+	}, "\n")
+	if buf.String() != want {
+		t.Errorf("WritePlan() = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestPlannerConflicts(t *testing.T) {
+	track := mockTag{album: "album", artist: "artist", track: 1, title: "title"}
+	target := filepath.Join("artist-album", "01-title.flac")
+
+	tests := []struct {
+		name     string
+		policy   ConflictPolicy
+		existing bool
+		want     []string
+	}{
+		{"skip existing", ConflictSkip, true, []string{}},
+		{"skip in-run", ConflictSkip, false, []string{target}},
+		{"rename existing", ConflictRename, true, []string{
+			filepath.Join("artist-album", "01-title (2).flac"),
+			filepath.Join("artist-album", "01-title (3).flac"),
+		}},
+		{"rename in-run", ConflictRename, false, []string{
+			target,
+			filepath.Join("artist-album", "01-title (2).flac"),
+		}},
+		{"overwrite existing", ConflictOverwrite, true, []string{target, target}},
+		{"overwrite in-run", ConflictOverwrite, false, []string{target, target}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			library := t.TempDir()
+			if tt.existing {
+				if err := os.MkdirAll(filepath.Join(library, "artist-album"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				writeTestFile(t, filepath.Join(library, target), "existing")
+			}
+
+			// two source albums whose tracks compute to the same target
+			sources := map[string][]musictagger.Music{}
+			for _, dir := range []string{"a", "b"} {
+				dir = filepath.Join(t.TempDir(), dir)
+				if err := os.MkdirAll(dir, 0755); err != nil {
+					t.Fatal(err)
+				}
+				sources[dir] = []musictagger.Music{{Path: filepath.Join(dir, "track.flac"), Metadata: track}}
+			}
+
+			planner := Planner{Library: library, OnConflict: tt.policy}
+			plans, err := planner.Plan(sources)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []string{}
+			for _, album := range plans {
+				for _, m := range album.Music {
+					rel, _ := filepath.Rel(library, m.Target)
+					got = append(got, rel)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planned targets = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlannerMaxAlbums(t *testing.T) {
+	source := t.TempDir()
+	library := map[string][]musictagger.Music{}
+	for _, album := range []string{"c", "a", "b"} {
+		dir := filepath.Join(source, album)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		library[dir] = []musictagger.Music{
+			{Path: filepath.Join(dir, "track.flac"), Metadata: mockTag{album: album, artist: "artist", track: 1, title: "title"}},
+		}
+	}
+
+	planner := Planner{Library: t.TempDir(), MaxAlbums: 2}
+	plans, err := planner.Plan(library)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, plan := range plans {
+		got = append(got, filepath.Base(plan.Source))
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("planned albums = %v, want %v", got, want)
+	}
+}
+
+func TestPlannerCaseOnlyRename(t *testing.T) {
+	library := t.TempDir()
+	album := filepath.Join(library, "artist-album")
+	if err := os.MkdirAll(album, 0755); err != nil {
+		t.Fatal(err)
+	}
+	source := filepath.Join(album, "01-Title.flac")
+	writeTestFile(t, source, "music")
+	if _, err := os.Stat(filepath.Join(album, "01-title.flac")); err != nil {
+		t.Skip("the filesystem is case-sensitive")
+	}
+
+	planner := Planner{Library: library}
+	plan, err := planner.PlanAlbum(album, []musictagger.Music{
+		{Path: source, Metadata: mockTag{album: "album", artist: "artist", track: 1, title: "title"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RenamePlan{{source, filepath.Join(album, "01-title.flac")}}
+	if !reflect.DeepEqual(plan.Music, want) {
+		t.Errorf("PlanAlbum() music = %v, want %v", plan.Music, want)
+	}
+}
+
+func TestPlannerExistingAlbum(t *testing.T) {
+	library := t.TempDir()
+	album := filepath.Join(library, "artist-album")
+	if err := os.MkdirAll(album, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(album, "01-a.flac"), "a")
+	writeTestFile(t, filepath.Join(album, "03-c.flac"), "an older c")
+	writeTestFile(t, filepath.Join(album, "04-d.flac"), "an older d")
+	writeTestFile(t, filepath.Join(album, "04-d (2).flac"), "d")
+
+	source := t.TempDir()
+	var music []musictagger.Music
+	for i, name := range []string{"a", "b", "c", "d"} {
+		path := filepath.Join(source, name+".flac")
+		writeTestFile(t, path, name)
+		music = append(music, musictagger.Music{Path: path, Metadata: mockTag{album: "album", artist: "artist", track: i + 1, title: name}})
+	}
+
+	planner := Planner{Library: library, OnConflict: ConflictRename}
+	plan, err := planner.PlanAlbum(source, music)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RenamePlan{
+		// a new track merges into the album
+		{filepath.Join(source, "b.flac"), filepath.Join(album, "02-b.flac")},
+		// a different file on the same path is a conflict
+		{filepath.Join(source, "c.flac"), filepath.Join(album, "03-c (2).flac")},
+	}
+	if !reflect.DeepEqual(plan.Music, want) {
+		t.Errorf("PlanAlbum() music = %v, want %v", plan.Music, want)
+	}
+	// an identical file is already imported, possibly renamed by an earlier
+	// run
+	if want := []string{filepath.Join(source, "a.flac"), filepath.Join(source, "d.flac")}; !reflect.DeepEqual(plan.Skipped, want) {
+		t.Errorf("PlanAlbum() skipped = %v, want %v", plan.Skipped, want)
+	}
+}
+
+func TestPlannerModifiedSince(t *testing.T) {
+	source := t.TempDir()
+	extras := filepath.Join(t.TempDir(), "extras")
+	library := map[string][]musictagger.Music{}
+	for _, album := range []string{"old", "new"} {
+		dir := filepath.Join(source, album)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(dir, "track.flac"), "music")
+		writeTestFile(t, filepath.Join(dir, "cover.jpg"), "cover")
+		library[dir] = []musictagger.Music{
+			{Path: filepath.Join(dir, "track.flac"), Metadata: mockTag{album: album, artist: "artist", track: 1, title: "title"}},
+		}
+	}
+
+	for _, dir := range []string{"old scans", "new scans"} {
+		if err := os.MkdirAll(filepath.Join(source, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(source, dir, "front.jpg"), "front")
+	}
+
+	cutoff := time.Now().Add(-time.Hour)
+	old := cutoff.Add(-time.Hour)
+	for _, file := range []string{filepath.Join("old", "track.flac"), filepath.Join("old", "cover.jpg"), filepath.Join("old scans", "front.jpg")} {
+		if err := os.Chtimes(filepath.Join(source, file), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	planner := Planner{Library: t.TempDir(), ModifiedSince: cutoff}
+	plans, err := planner.Plan(library)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, plan := range plans {
+		for _, m := range plan.Moves() {
+			rel, _ := filepath.Rel(source, m.Source)
+			got = append(got, rel)
+		}
+	}
+	want := []string{filepath.Join("new", "cover.jpg"), filepath.Join("new", "track.flac")}
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("planned moves = %v, want %v", got, want)
+	}
+
+	// the old album is still an album, not a pile of non-music files, and
+	// old non-music files stay too
+	extraMoves, err := planner.PlanNonMusic(source, library, extras)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantExtras := []RenamePlan{{filepath.Join(source, "new scans", "front.jpg"), filepath.Join(extras, "new scans", "front.jpg")}}
+	if !reflect.DeepEqual(extraMoves, wantExtras) {
+		t.Errorf("PlanNonMusic() = %v, want %v", extraMoves, wantExtras)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	var plans []AlbumPlan
+	for _, dir := range []string{"a", "b", "c", "d", "e"} {
+		plans = append(plans, AlbumPlan{Source: dir})
+	}
+
+	t.Run("processes every album once", func(t *testing.T) {
+		var mu sync.Mutex
+		seen := map[string]int{}
+		err := ForEach(context.Background(), plans, 3, func(_ context.Context, plan AlbumPlan) {
+			mu.Lock()
+			defer mu.Unlock()
+			seen[plan.Source]++
+		})
+		if err != nil {
+			t.Fatalf("ForEach() error = %v", err)
+		}
+
+		for _, plan := range plans {
+			if seen[plan.Source] != 1 {
+				t.Errorf("album %s processed %d times, want 1", plan.Source, seen[plan.Source])
+			}
+		}
+	})
+
+	t.Run("stops when cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var seen []string
+		err := ForEach(ctx, plans, 1, func(_ context.Context, plan AlbumPlan) {
+			seen = append(seen, plan.Source)
+			if len(seen) == 2 {
+				cancel()
+			}
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ForEach() error = %v, want %v", err, context.Canceled)
+		}
+		// the worker may already be waiting for the third album when the
+		// second one cancels
+		if len(seen) < 2 || len(seen) > 3 {
+			t.Errorf("processed albums = %v, want 2 or 3", seen)
+		}
+	})
+}
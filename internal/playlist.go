@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WritePlaylist writes an .m3u8 playlist named after the album's target
+// directory into that directory. It lists the album's tracks in disc and
+// track order, with paths relative to the playlist. Tracks that didn't make it
+// into the directory are left out, and no playlist is written if none did.
+func WritePlaylist(plan AlbumPlan) error {
+	type entry struct {
+		disc, track int
+		path        string
+	}
+
+	var entries []entry
+	for _, m := range plan.Tracks {
+		target, ok := plan.Placed[m.Path]
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(target); err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(plan.Target, target)
+		if err != nil {
+			return err
+		}
+
+		disc, _ := m.Metadata.Disc()
+		track, _ := m.Metadata.Track()
+		entries = append(entries, entry{disc, track, filepath.ToSlash(rel)})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].disc != entries[j].disc {
+			return entries[i].disc < entries[j].disc
+		}
+		return entries[i].track < entries[j].track
+	})
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, e := range entries {
+		fmt.Fprintln(&b, e.path)
+	}
+
+	name := filepath.Base(plan.Target) + ".m3u8"
+	return os.WriteFile(filepath.Join(plan.Target, name), []byte(b.String()), 0644)
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+func TestWritePlaylist(t *testing.T) {
+	library := t.TempDir()
+	source := t.TempDir()
+
+	music := []musictagger.Music{
+		{Path: filepath.Join(source, "c.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 1, title: "c", disc: 2, discs: 2}},
+		{Path: filepath.Join(source, "b.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 2, title: "b", disc: 1, discs: 2}},
+		{Path: filepath.Join(source, "a.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 1, title: "a", disc: 1, discs: 2}},
+	}
+	for _, m := range music {
+		writeTestFile(t, m.Path, "music")
+	}
+
+	planner := Planner{Library: library}
+	plan, err := planner.PlanAlbum(source, music)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(plan.Target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range plan.Music {
+		if err := MoveFile(m.Source, m.Target, ModeMove); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := WritePlaylist(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(library, "artist-album", "artist-album.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n1-01-a.flac\n1-02-b.flac\n2-01-c.flac\n"
+	if string(b) != want {
+		t.Errorf("playlist = %q, want %q", b, want)
+	}
+}
+
+func TestWritePlaylistNoMusic(t *testing.T) {
+	dir := t.TempDir()
+	plan := AlbumPlan{
+		Source: dir,
+		Target: dir,
+		Tracks: []musictagger.Music{{Path: filepath.Join(dir, "gone.flac"), Metadata: mockTag{track: 1}}},
+		Placed: map[string]string{filepath.Join(dir, "gone.flac"): filepath.Join(dir, "gone.flac")},
+	}
+	if err := WritePlaylist(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("playlist written for an album without music: %v", entries)
+	}
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+// PruneEmptyDirs removes the directories under root that are empty, or only
+// contain directories that are, deepest first. root itself is never removed,
+// and neither are the directories in keep, such as the library, or ones
+// filters reject, nor anything below them. Files in gone count as already
+// moved away, which lets a dry run, which only logs what it would remove,
+// predict which directories a real run leaves empty. It returns the
+// directories removed.
+func PruneEmptyDirs(root string, keep []string, gone map[string]bool, dry bool, filters ...musictagger.Filter) ([]string, error) {
+	// the walk yields paths spelled after root, so compare absolute paths
+	// to recognise the kept directories however they're given
+	skip := map[string]bool{}
+	for _, dir := range keep {
+		if dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		skip[abs] = true
+	}
+
+	var dirs []string
+	if err := filepath.WalkDir(root, func(s string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() || s == root {
+			return nil
+		}
+		abs, err := filepath.Abs(s)
+		if err != nil {
+			return err
+		}
+		if skip[abs] || !musictagger.Accept(filters, s, d) {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, s)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	removed := map[string]bool{}
+	var pruned []string
+	// WalkDir visits parents before their children, so going backwards
+	// handles the children first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return pruned, err
+		}
+
+		empty := true
+		for _, e := range entries {
+			if p := filepath.Join(dir, e.Name()); !removed[p] && !gone[p] {
+				empty = false
+				break
+			}
+		}
+		if !empty {
+			continue
+		}
+
+		logger := log.WithFields(log.Fields{"path": dir, "action": "prune"})
+		if dry {
+			logger.Infof("would remove empty directory %s", dir)
+		} else {
+			logger.Infof("removing empty directory %s", dir)
+			if err := os.Remove(dir); err != nil {
+				return pruned, err
+			}
+		}
+		removed[dir] = true
+		pruned = append(pruned, dir)
+	}
+	return pruned, nil
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+func TestPruneEmptyDirs(t *testing.T) {
+	setup := func(t *testing.T) string {
+		root := t.TempDir()
+		for _, dir := range []string{
+			filepath.Join("a", "b", "c"),
+			filepath.Join("a", "d"),
+			filepath.Join("e"),
+		} {
+			if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeTestFile(t, filepath.Join(root, "a", "d", "cover.jpg"), "cover")
+		writeTestFile(t, filepath.Join(root, "e", "track.flac"), "music")
+		return root
+	}
+
+	t.Run("removes empty directories", func(t *testing.T) {
+		root := setup(t)
+		got, err := PruneEmptyDirs(root, nil, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{filepath.Join(root, "a", "b", "c"), filepath.Join(root, "a", "b")}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PruneEmptyDirs() = %v, want %v", got, want)
+		}
+		if _, err := os.Stat(filepath.Join(root, "a", "b")); !os.IsNotExist(err) {
+			t.Errorf("%s still exists", filepath.Join(root, "a", "b"))
+		}
+		if _, err := os.Stat(filepath.Join(root, "a", "d")); err != nil {
+			t.Errorf("non-empty directory was removed: %v", err)
+		}
+	})
+
+	t.Run("never removes the root", func(t *testing.T) {
+		root := t.TempDir()
+		got, err := PruneEmptyDirs(root, nil, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Errorf("PruneEmptyDirs() = %v, want none", got)
+		}
+		if _, err := os.Stat(root); err != nil {
+			t.Errorf("root was removed: %v", err)
+		}
+	})
+
+	t.Run("leaves kept and ignored directories alone", func(t *testing.T) {
+		root := setup(t)
+		for _, dir := range []string{filepath.Join("library", "empty"), filepath.Join("@eaDir", "empty")} {
+			if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := PruneEmptyDirs(root, []string{filepath.Join(root, "library")}, nil, false, musictagger.IgnorePatterns("@eaDir"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{filepath.Join(root, "a", "b", "c"), filepath.Join(root, "a", "b")}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PruneEmptyDirs() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		root := setup(t)
+		gone := map[string]bool{
+			filepath.Join(root, "a", "d", "cover.jpg"): true,
+		}
+		got, err := PruneEmptyDirs(root, nil, gone, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{
+			filepath.Join(root, "a", "d"),
+			filepath.Join(root, "a", "b", "c"),
+			filepath.Join(root, "a", "b"),
+			filepath.Join(root, "a"),
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PruneEmptyDirs() = %v, want %v", got, want)
+		}
+		if _, err := os.Stat(filepath.Join(root, "a", "b", "c")); err != nil {
+			t.Errorf("dry run removed a directory: %v", err)
+		}
+	})
+}
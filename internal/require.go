@@ -0,0 +1,31 @@
+package internal
+
+import "github.com/dhowden/tag"
+
+// RequiredFields are the tags Planner.RequireFields can name.
+var RequiredFields = []string{"artist", "album", "title", "track"}
+
+// missingFields returns those of fields that are empty in m. The artist
+// counts as present when either the artist or the album artist is set, as
+// either is enough to name the album directory.
+func missingFields(m tag.Metadata, fields []string) []string {
+	var missing []string
+	for _, f := range fields {
+		var empty bool
+		switch f {
+		case "artist":
+			empty = m.Artist() == "" && m.AlbumArtist() == ""
+		case "album":
+			empty = m.Album() == ""
+		case "title":
+			empty = m.Title() == ""
+		case "track":
+			track, _ := m.Track()
+			empty = track == 0
+		}
+		if empty {
+			missing = append(missing, f)
+		}
+	}
+	return missing
+}
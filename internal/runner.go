@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"context"
+	"maps"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+// Runner carries out the moves computed by a Planner.
+type Runner struct {
+	Mover FileMover
+	// Journal, if set, records every successful move.
+	Journal *Journal
+
+	// AlbumJSON writes an album.json into every album directory.
+	AlbumJSON bool
+	// NFO writes an album.nfo into every album directory that doesn't have
+	// one yet.
+	NFO bool
+	// Playlist writes an .m3u8 playlist into every album directory.
+	Playlist bool
+
+	// Confirm, if set, is asked before every album is run, and albums it
+	// declines are left alone. With several workers it's called
+	// concurrently. It's also asked about the files RunNonMusic moves, as
+	// one album of companions.
+	Confirm func(album AlbumPlan) bool
+
+	mu      sync.Mutex
+	summary Summary
+	// ran holds the albums run so far, with Placed trimmed to the tracks
+	// that actually got there.
+	ran []AlbumPlan
+}
+
+// Summary returns what the runner has done so far.
+func (r *Runner) Summary() Summary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.summary
+}
+
+// count updates the summary, which albums running in parallel share.
+func (r *Runner) count(f func(s *Summary)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(&r.summary)
+}
+
+// Place moves a single file, creating its directory first. Failures are
+// logged, and it reports whether the move succeeded.
+func (r *Runner) Place(m RenamePlan) bool {
+	logger := log.WithFields(log.Fields{"path": m.Source, "target": m.Target, "action": "move"})
+
+	// another album may be creating the same directory concurrently, which
+	// MkdirAll is fine with
+	if err := r.Mover.MkdirAll(filepath.Dir(m.Target), 0755); err != nil {
+		logger.Warn(err)
+		r.count(func(s *Summary) { s.Errors++ })
+		return false
+	}
+
+	logger.Infof("renaming %s to %s", m.Source, m.Target)
+	if err := r.Mover.Move(m.Source, m.Target); err != nil {
+		logger.Warn(err)
+		r.count(func(s *Summary) { s.Errors++ })
+		return false
+	}
+	r.count(func(s *Summary) { s.FilesMoved++ })
+	if err := r.Journal.Record(m.Source, m.Target); err != nil {
+		log.Warn(err)
+	}
+	return true
+}
+
+// RunAlbum moves an album's music and, unless the album is flat, its
+// companions, provided Confirm approves of it. ctx is checked before every
+// move, and if it's cancelled RunAlbum stops and returns ctx.Err(), possibly
+// leaving the album half moved. The album-level files are written by
+// WriteAlbumFiles once all albums have run.
+func (r *Runner) RunAlbum(ctx context.Context, album AlbumPlan) error {
+	if r.Confirm != nil && !r.Confirm(album) {
+		log.WithFields(log.Fields{"path": album.Source, "action": "skip"}).Infof("skipping %s", album.Source)
+		r.count(func(s *Summary) { s.FilesSkipped += len(album.Moves()) + len(album.Skipped) })
+		return nil
+	}
+
+	r.count(func(s *Summary) {
+		s.AlbumsProcessed++
+		s.FilesSkipped += len(album.Skipped)
+	})
+
+	placed := maps.Clone(album.Placed)
+	defer func() {
+		album.Placed = placed
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.ran = append(r.ran, album)
+	}()
+
+	for _, m := range album.Music {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !r.Place(m) {
+			delete(placed, m.Source)
+		}
+	}
+
+	// in flat mode there's no album directory for other files to go to
+	if album.Flat {
+		return nil
+	}
+
+	// if there's any other files in the directory, move them too
+	for _, m := range album.Companions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.Place(m)
+	}
+	return nil
+}
+
+// RunNonMusic moves the files of source's directories without music, as
+// planned by Planner.PlanNonMusic, asking Confirm about all of them at once.
+// Like RunAlbum it stops when ctx is cancelled and returns ctx.Err().
+func (r *Runner) RunNonMusic(ctx context.Context, source string, moves []RenamePlan) error {
+	if len(moves) == 0 {
+		return nil
+	}
+	if r.Confirm != nil && !r.Confirm(AlbumPlan{Source: source, Companions: moves}) {
+		log.WithFields(log.Fields{"path": source, "action": "skip"}).Infof("skipping the non-music files of %s", source)
+		r.count(func(s *Summary) { s.FilesSkipped += len(moves) })
+		return nil
+	}
+
+	for _, m := range moves {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r.Place(m)
+	}
+	return nil
+}
+
+// WriteAlbumFiles writes the enabled album-level files into every album
+// directory tracks were placed in by the albums run so far. It runs once
+// after all of them, because several source directories, such as CD1 and
+// CD2, may fill the same album directory.
+func (r *Runner) WriteAlbumFiles() {
+	r.mu.Lock()
+	ran := slices.Clone(r.ran)
+	r.mu.Unlock()
+
+	for _, album := range AlbumsByTarget(ran) {
+		if r.AlbumJSON {
+			if err := musictagger.NewAlbum(album.Tracks).WriteJSON(album.Target); err != nil {
+				log.Warn(err)
+				r.count(func(s *Summary) { s.Errors++ })
+			}
+		}
+		if r.NFO {
+			if err := musictagger.NewAlbum(album.Tracks).WriteNFO(album.Target); err != nil {
+				log.Warn(err)
+				r.count(func(s *Summary) { s.Errors++ })
+			}
+		}
+		if r.Playlist {
+			if err := WritePlaylist(album); err != nil {
+				log.Warn(err)
+				r.count(func(s *Summary) { s.Errors++ })
+			}
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+// fakeMover records calls instead of touching the filesystem.
+type fakeMover struct {
+	calls []string
+	// onMove, if set, is called after every move.
+	onMove func()
+}
+
+func (f *fakeMover) Move(src, dst string) error {
+	f.calls = append(f.calls, fmt.Sprintf("move %s %s", src, dst))
+	if f.onMove != nil {
+		f.onMove()
+	}
+	return nil
+}
+
+func (f *fakeMover) MkdirAll(path string, perm os.FileMode) error {
+	f.calls = append(f.calls, fmt.Sprintf("mkdir %s", path))
+	return nil
+}
+
+func TestRunnerRunAlbum(t *testing.T) {
+	library := t.TempDir()
+	source := t.TempDir()
+	writeTestFile(t, filepath.Join(source, "a.flac"), "a")
+	writeTestFile(t, filepath.Join(source, "b.flac"), "b")
+	writeTestFile(t, filepath.Join(source, "cover.jpg"), "cover")
+
+	planner := Planner{Library: library}
+	plan, err := planner.PlanAlbum(source, []musictagger.Music{
+		{Path: filepath.Join(source, "a.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 1, title: "a"}},
+		{Path: filepath.Join(source, "b.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 2, title: "b"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mover := &fakeMover{}
+	runner := Runner{Mover: mover}
+	if err := runner.RunAlbum(context.Background(), plan); err != nil {
+		t.Fatal(err)
+	}
+
+	album := filepath.Join(library, "artist-album")
+	want := []string{
+		"mkdir " + album,
+		fmt.Sprintf("move %s %s", filepath.Join(source, "a.flac"), filepath.Join(album, "01-a.flac")),
+		"mkdir " + album,
+		fmt.Sprintf("move %s %s", filepath.Join(source, "b.flac"), filepath.Join(album, "02-b.flac")),
+		"mkdir " + album,
+		fmt.Sprintf("move %s %s", filepath.Join(source, "cover.jpg"), filepath.Join(album, "cover.jpg")),
+	}
+	if !reflect.DeepEqual(mover.calls, want) {
+		t.Errorf("mover calls = %v, want %v", mover.calls, want)
+	}
+
+	// nothing was actually moved
+	if _, err := os.Stat(filepath.Join(source, "a.flac")); err != nil {
+		t.Errorf("source was touched: %v", err)
+	}
+}
+
+func TestRunnerConfirm(t *testing.T) {
+	library := t.TempDir()
+	sources := map[string][]musictagger.Music{}
+	var approved string
+	for _, album := range []string{"approved", "declined"} {
+		dir := filepath.Join(t.TempDir(), album)
+		if album == "approved" {
+			approved = filepath.Join(dir, "a.flac")
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		sources[dir] = []musictagger.Music{
+			{Path: filepath.Join(dir, "a.flac"), Metadata: mockTag{album: album, artist: "artist", track: 1, title: "a"}},
+		}
+	}
+
+	planner := Planner{Library: library}
+	plans, err := planner.Plan(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mover := &fakeMover{}
+	runner := Runner{
+		Mover: mover,
+		Confirm: func(album AlbumPlan) bool {
+			return filepath.Base(album.Source) == "approved"
+		},
+	}
+	for _, plan := range plans {
+		if err := runner.RunAlbum(context.Background(), plan); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	album := filepath.Join(library, "artist-approved")
+	want := []string{
+		"mkdir " + album,
+		fmt.Sprintf("move %s %s", approved, filepath.Join(album, "01-a.flac")),
+	}
+	if !reflect.DeepEqual(mover.calls, want) {
+		t.Errorf("mover calls = %v, want %v", mover.calls, want)
+	}
+	if got, want := runner.Summary(), (Summary{AlbumsProcessed: 1, FilesMoved: 1, FilesSkipped: 1}); got != want {
+		t.Errorf("Summary() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRunnerRunNonMusic(t *testing.T) {
+	moves := []RenamePlan{
+		{"/src/scans/front.jpg", "/extras/scans/front.jpg"},
+		{"/src/notes.txt", "/extras/notes.txt"},
+	}
+	for _, approve := range []bool{true, false} {
+		t.Run(fmt.Sprintf("approve %v", approve), func(t *testing.T) {
+			var asked []RenamePlan
+			mover := &fakeMover{}
+			runner := Runner{
+				Mover: mover,
+				Confirm: func(album AlbumPlan) bool {
+					asked = append(asked, album.Moves()...)
+					return approve
+				},
+			}
+			if err := runner.RunNonMusic(context.Background(), "/src", moves); err != nil {
+				t.Fatal(err)
+			}
+
+			// they're confirmed as one batch
+			if !reflect.DeepEqual(asked, moves) {
+				t.Errorf("confirmed moves = %v, want %v", asked, moves)
+			}
+			want := Summary{FilesSkipped: 2}
+			if approve {
+				want = Summary{FilesMoved: 2}
+			}
+			if got := runner.Summary(); got != want {
+				t.Errorf("Summary() = %+v, want %+v", got, want)
+			}
+			if got := len(mover.calls) > 0; got != approve {
+				t.Errorf("mover calls = %v, want moves %v", mover.calls, approve)
+			}
+		})
+	}
+}
+
+func TestRunnerNothingPlaced(t *testing.T) {
+	tests := []struct {
+		name    string
+		planner Planner
+	}{
+		{"required tags missing", Planner{RequireFields: []string{"album"}}},
+		{"every track conflicts", Planner{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			library := t.TempDir()
+			existing := filepath.Join(library, "artist-album")
+			if err := os.MkdirAll(existing, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, filepath.Join(existing, "01-a.flac"), "existing")
+			writeTestFile(t, filepath.Join(existing, musictagger.AlbumJSONFile), "existing")
+
+			source := t.TempDir()
+			writeTestFile(t, filepath.Join(source, "a.flac"), "a")
+			tt.planner.Library = library
+			plan, err := tt.planner.PlanAlbum(source, []musictagger.Music{
+				{Path: filepath.Join(source, "a.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 1, title: "a"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			runner := Runner{Mover: &fakeMover{}, AlbumJSON: true, NFO: true}
+			if err := runner.RunAlbum(context.Background(), plan); err != nil {
+				t.Fatal(err)
+			}
+			runner.WriteAlbumFiles()
+
+			for _, path := range []string{
+				filepath.Join(library, musictagger.AlbumJSONFile),
+				filepath.Join(library, musictagger.AlbumNFOFile),
+				filepath.Join(existing, musictagger.AlbumNFOFile),
+			} {
+				if _, err := os.Stat(path); err == nil {
+					t.Errorf("%s was written", path)
+				}
+			}
+			if b, _ := os.ReadFile(filepath.Join(existing, musictagger.AlbumJSONFile)); string(b) != "existing" {
+				t.Errorf("existing album.json was overwritten with %s", b)
+			}
+		})
+	}
+}
+
+func TestRunnerWriteAlbumFilesMultiDisc(t *testing.T) {
+	library := t.TempDir()
+	source := t.TempDir()
+	sources := map[string][]musictagger.Music{}
+	for disc, name := range []string{"b", "a"} {
+		dir := filepath.Join(source, fmt.Sprintf("CD%d", disc+1))
+		m := musictagger.Music{
+			Path:     filepath.Join(dir, name+".flac"),
+			Metadata: mockTag{album: "album", artist: "artist", track: 1, title: name, disc: disc + 1, discs: 2},
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, m.Path, name)
+		sources[dir] = []musictagger.Music{m}
+	}
+
+	planner := Planner{Library: library}
+	plans, err := planner.Plan(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runner := Runner{Mover: OSMover{Mode: ModeMove}, AlbumJSON: true, Playlist: true}
+	err = ForEach(context.Background(), plans, 2, func(ctx context.Context, album AlbumPlan) {
+		if err := runner.RunAlbum(ctx, album); err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.WriteAlbumFiles()
+
+	album := filepath.Join(library, "artist-album")
+	b, err := os.ReadFile(filepath.Join(album, "artist-album.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "#EXTM3U\n1-01-b.flac\n2-01-a.flac\n"; string(b) != want {
+		t.Errorf("playlist = %q, want %q", b, want)
+	}
+
+	b, err = os.ReadFile(filepath.Join(album, musictagger.AlbumJSONFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got musictagger.Album
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Tracks) != 2 {
+		t.Errorf("album.json tracks = %+v, want both discs", got.Tracks)
+	}
+	if got := runner.Summary().Errors; got != 0 {
+		t.Errorf("Summary().Errors = %d, want 0", got)
+	}
+}
+
+func TestRunnerRunAlbumCancelled(t *testing.T) {
+	library := t.TempDir()
+	source := t.TempDir()
+	writeTestFile(t, filepath.Join(source, "cover.jpg"), "cover")
+
+	planner := Planner{Library: library}
+	plan, err := planner.PlanAlbum(source, []musictagger.Music{
+		{Path: filepath.Join(source, "a.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 1, title: "a"}},
+		{Path: filepath.Join(source, "b.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 2, title: "b"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mover := &fakeMover{onMove: cancel}
+	runner := Runner{Mover: mover}
+
+	if err := runner.RunAlbum(ctx, plan); !errors.Is(err, context.Canceled) {
+		t.Errorf("RunAlbum() error = %v, want %v", err, context.Canceled)
+	}
+
+	album := filepath.Join(library, "artist-album")
+	want := []string{
+		"mkdir " + album,
+		fmt.Sprintf("move %s %s", filepath.Join(source, "a.flac"), filepath.Join(album, "01-a.flac")),
+	}
+	if !reflect.DeepEqual(mover.calls, want) {
+		t.Errorf("mover calls = %v, want %v", mover.calls, want)
+	}
+}
+
+func TestRunnerSummary(t *testing.T) {
+	library := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(library, "artist-album"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(library, "artist-album", "02-b.flac"), "existing")
+
+	sources := map[string][]musictagger.Music{}
+	for _, dir := range []string{"one", "two"} {
+		dir = filepath.Join(t.TempDir(), dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(dir, "cover.jpg"), "cover")
+		sources[dir] = []musictagger.Music{
+			{Path: filepath.Join(dir, "a.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 1, title: "a"}},
+			{Path: filepath.Join(dir, "b.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 2, title: "b"}},
+		}
+	}
+
+	planner := Planner{Library: library}
+	plans, err := planner.Plan(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first album moves a.flac and cover.jpg, the second one conflicts
+	// on every file
+	want := Summary{AlbumsProcessed: 2, FilesMoved: 2, FilesSkipped: 4}
+	if got := Summarize(plans, nil); got != want {
+		t.Errorf("Summarize() = %+v, want %+v", got, want)
+	}
+
+	runner := Runner{Mover: &fakeMover{}}
+	if err := ForEach(context.Background(), plans, 2, func(ctx context.Context, album AlbumPlan) {
+		runner.RunAlbum(ctx, album)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := runner.Summary(); got != want {
+		t.Errorf("Summary() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRunnerPlaceLogFields(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFormatter(&log.JSONFormatter{})
+	defer func() {
+		log.SetOutput(os.Stderr)
+		log.SetFormatter(&log.TextFormatter{})
+	}()
+
+	runner := Runner{Mover: &fakeMover{}}
+	runner.Place(RenamePlan{"/src/a.flac", "/lib/artist-album/01-a.flac"})
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log output %q is not JSON: %v", buf.String(), err)
+	}
+	for field, want := range map[string]string{
+		"path":   "/src/a.flac",
+		"target": "/lib/artist-album/01-a.flac",
+		"action": "move",
+	} {
+		if entry[field] != want {
+			t.Errorf("log field %s = %v, want %v", field, entry[field], want)
+		}
+	}
+}
+
+func TestRunnerPreservesModTime(t *testing.T) {
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	for _, mode := range []Mode{ModeMove, ModeCopy, ModeHardlink, ModeSymlink} {
+		t.Run(string(mode), func(t *testing.T) {
+			src := filepath.Join(t.TempDir(), "a.flac")
+			dst := filepath.Join(t.TempDir(), "artist-album", "01-a.flac")
+			writeTestFile(t, src, "a")
+			if err := os.Chtimes(src, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+
+			runner := Runner{Mover: OSMover{Mode: mode}}
+			if !runner.Place(RenamePlan{src, dst}) {
+				t.Fatal("Place() failed")
+			}
+
+			fi, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fi.ModTime().Equal(mtime) {
+				t.Errorf("target mtime = %v, want %v", fi.ModTime(), mtime)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package internal
+
+// Summary counts what a run did, or in a dry run what it would have done.
+type Summary struct {
+	AlbumsProcessed int `json:"albums_processed"`
+	FilesMoved      int `json:"files_moved"`
+	FilesSkipped    int `json:"files_skipped"`
+	Errors          int `json:"errors"`
+}
+
+// Summarize counts the moves a run of plans and extras would make without
+// running them.
+func Summarize(plans []AlbumPlan, extras []RenamePlan) Summary {
+	s := Summary{AlbumsProcessed: len(plans), FilesMoved: len(extras)}
+	for _, album := range plans {
+		s.FilesMoved += len(album.Moves())
+		s.FilesSkipped += len(album.Skipped)
+	}
+	return s
+}
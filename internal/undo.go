@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Undo replays the journal at journalPath in reverse, moving every file back
+// to where it came from with mover. Copies and links, which left their source
+// in place, are removed instead, unless the source is gone and a copy is all
+// that's left of it. Entries whose target is gone, or whose original location
+// is occupied again, are skipped. It returns how many moves were reverted.
+func Undo(journalPath string, mover FileMover) (int, error) {
+	f, err := os.Open(journalPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return 0, err
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		logger := log.WithFields(log.Fields{"path": e.To, "target": e.From, "action": "undo"})
+
+		fi, err := os.Lstat(e.To)
+		if err != nil {
+			logger.Warnf("%s no longer exists, not moving it back to %s", e.To, e.From)
+			continue
+		}
+		_, err = os.Lstat(e.From)
+		source := err == nil
+
+		// a symlink is worthless without its source, and a copy or hard link
+		// is redundant with it
+		isLink := fi.Mode()&os.ModeSymlink != 0
+		if e.Mode != "" && e.Mode != ModeMove && (source || isLink) {
+			logger.Infof("removing %s", e.To)
+			if err := os.Remove(e.To); err != nil {
+				logger.Warn(err)
+				continue
+			}
+			reverted++
+			continue
+		}
+
+		if source {
+			logger.Warnf("%s already exists, not moving %s back", e.From, e.To)
+			continue
+		}
+
+		logger.Infof("renaming %s to %s", e.To, e.From)
+		if err := mover.MkdirAll(filepath.Dir(e.From), 0755); err != nil {
+			return reverted, err
+		}
+		if err := mover.Move(e.To, e.From); err != nil {
+			logger.Warn(err)
+			continue
+		}
+		reverted++
+	}
+
+	return reverted, nil
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkazmierczak/musictagger"
+)
+
+func TestUndo(t *testing.T) {
+	library := t.TempDir()
+	source := filepath.Join(t.TempDir(), "album")
+	if err := os.MkdirAll(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(source, "a.flac"), "a")
+	writeTestFile(t, filepath.Join(source, "b.flac"), "b")
+	writeTestFile(t, filepath.Join(source, "cover.jpg"), "cover")
+
+	music := []musictagger.Music{
+		{Path: filepath.Join(source, "a.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 1, title: "a"}},
+		{Path: filepath.Join(source, "b.flac"), Metadata: mockTag{album: "album", artist: "artist", track: 2, title: "b"}},
+	}
+	planner := Planner{Library: library}
+	plan, err := planner.PlanAlbum(source, music)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := OpenJournal(journalPath, ModeMove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(plan.Target, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range plan.Moves() {
+		if err := MoveFile(m.Source, m.Target, ModeMove); err != nil {
+			t.Fatal(err)
+		}
+		if err := journal.Record(m.Source, m.Target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file that has since disappeared from the library is skipped
+	if err := os.Remove(filepath.Join(plan.Target, "02-b.flac")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(source); err != nil {
+		t.Fatal(err)
+	}
+
+	reverted, err := Undo(journalPath, OSMover{Mode: ModeMove})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted != 2 {
+		t.Errorf("Undo() reverted %d moves, want 2", reverted)
+	}
+
+	for name, want := range map[string]string{"a.flac": "a", "cover.jpg": "cover"} {
+		b, err := os.ReadFile(filepath.Join(source, name))
+		if err != nil {
+			t.Errorf("%s was not restored: %v", name, err)
+			continue
+		}
+		if string(b) != want {
+			t.Errorf("%s content = %q, want %q", name, b, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(source, "b.flac")); err == nil {
+		t.Errorf("b.flac was restored even though its target was gone")
+	}
+}
+
+func TestUndoLeftInPlace(t *testing.T) {
+	for _, mode := range []Mode{ModeCopy, ModeHardlink, ModeSymlink} {
+		t.Run(string(mode), func(t *testing.T) {
+			source := t.TempDir()
+			library := t.TempDir()
+			journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+			journal, err := OpenJournal(journalPath, mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"a.flac", "b.flac"} {
+				src, dst := filepath.Join(source, name), filepath.Join(library, name)
+				writeTestFile(t, src, name)
+				if err := MoveFile(src, dst, mode); err != nil {
+					t.Fatal(err)
+				}
+				if err := journal.Record(src, dst); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := journal.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// without its source, a copy is moved back rather than lost
+			if err := os.Remove(filepath.Join(source, "b.flac")); err != nil {
+				t.Fatal(err)
+			}
+
+			reverted, err := Undo(journalPath, OSMover{Mode: ModeMove})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reverted != 2 {
+				t.Errorf("Undo() reverted %d moves, want 2", reverted)
+			}
+
+			if _, err := os.Lstat(filepath.Join(library, "a.flac")); err == nil {
+				t.Errorf("a.flac was left in the library")
+			}
+			if b, err := os.ReadFile(filepath.Join(source, "a.flac")); string(b) != "a.flac" {
+				t.Errorf("a.flac source = %q, %v, want it untouched", b, err)
+			}
+
+			b, err := os.ReadFile(filepath.Join(source, "b.flac"))
+			if mode == ModeSymlink {
+				if err == nil {
+					t.Errorf("b.flac was restored from a dangling symlink")
+				}
+				return
+			}
+			if string(b) != "b.flac" {
+				t.Errorf("b.flac source = %q, %v, want it moved back", b, err)
+			}
+		})
+	}
+}
@@ -3,13 +3,184 @@ package internal
 import (
 	"fmt"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/dhowden/tag"
 )
 
-func ComputeTargetPath(source tag.Metadata, originalPath string, replacementsTable map[string]string) string {
+// PathOptions tweaks how ComputeTargetPath lays out the target path.
+type PathOptions struct {
+	// Flat puts every file directly in the library root, naming it
+	// artist-album-track-title instead of using an album directory.
+	Flat bool
+
+	// StripTrackPrefix removes a leading track number from titles such as
+	// "01 - Intro", but only when it matches the track tag.
+	StripTrackPrefix bool
+
+	// CollapseSeparators squashes runs of "-", "_" and " " into a single
+	// separator and trims them from the ends of each path component, which
+	// cleans up after empty tags.
+	CollapseSeparators bool
+
+	// MissingTrack decides how files without a track number are named.
+	MissingTrack MissingTrackStrategy
+
+	// TrackWidth is the number of digits track numbers are zero-padded to,
+	// 2 when unset. DynamicTrackPadding instead pads every album to the
+	// width of its largest track number or total, but never below 2. It
+	// needs the whole album and is applied by Planner.
+	TrackWidth          int
+	DynamicTrackPadding bool
+
+	// StripArticles lists leading articles, e.g. "The", to take off artist
+	// names so that "The Beatles" is filed under "Beatles". ArticleStyle
+	// decides what happens to them.
+	StripArticles []string
+	ArticleStyle  ArticleStyle
+
+	// ArtistInitial files every album directory under a directory named
+	// after the first letter of its artist, e.g. "B/beatles-abbey_road".
+	// Artists that don't start with a letter go under "#". It's ignored in
+	// flat mode.
+	ArtistInitial bool
+
+	// WindowsSafe replaces characters Windows and SMB shares don't allow in
+	// file names, and strips trailing spaces and dots.
+	WindowsSafe bool
+}
+
+// MissingTrackStrategy decides how files without a track number are named, so
+// that several of them in one album don't all end up as "00-...".
+type MissingTrackStrategy string
+
+const (
+	// MissingTrackZero numbers them 00, which is the default.
+	MissingTrackZero MissingTrackStrategy = "zero"
+	// MissingTrackIndex numbers them sequentially after the album's highest
+	// track number. It needs the whole album and is applied by Planner.
+	MissingTrackIndex MissingTrackStrategy = "index"
+	// MissingTrackFilename keeps the original file name instead.
+	MissingTrackFilename MissingTrackStrategy = "filename"
+)
+
+// ArticleStyle decides what happens to a leading article stripped from an
+// artist name.
+type ArticleStyle string
+
+const (
+	// ArticleRemove drops the article, which is the default.
+	ArticleRemove ArticleStyle = "remove"
+	// ArticleMoveToEnd moves it to the end, as in "Beatles, The".
+	ArticleMoveToEnd ArticleStyle = "move-to-end"
+)
+
+// stripArticle takes the first of articles that starts name as a whole word
+// off it. Names that consist of nothing but the article are left alone.
+func stripArticle(name string, articles []string, style ArticleStyle) string {
+	for _, a := range articles {
+		if len(name) <= len(a) || !strings.EqualFold(name[:len(a)], a) || name[len(a)] != ' ' {
+			continue
+		}
+		rest := strings.TrimLeft(name[len(a):], " ")
+		if rest == "" {
+			continue
+		}
+		if style == ArticleMoveToEnd {
+			return rest + ", " + name[:len(a)]
+		}
+		return rest
+	}
+	return name
+}
+
+var trackPrefix = regexp.MustCompile(`^(\d+)[\s._-]+(.+)$`)
+
+// stripTrackPrefix returns title without its leading number if that number
+// equals track.
+func stripTrackPrefix(title string, track int) string {
+	parts := trackPrefix.FindStringSubmatch(title)
+	if parts == nil {
+		return title
+	}
+	if n, err := strconv.Atoi(parts[1]); err != nil || n != track {
+		return title
+	}
+	return parts[2]
+}
+
+var separatorRun = regexp.MustCompile(`[-_ ]+`)
+
+// collapseSeparators replaces every run of separators in s with a single one,
+// preferring "-" when the run contains it, and trims separators from both ends.
+func collapseSeparators(s string) string {
+	s = separatorRun.ReplaceAllStringFunc(s, func(run string) string {
+		if strings.Contains(run, "-") {
+			return "-"
+		}
+		return run[:1]
+	})
+	return strings.Trim(s, "-_ ")
+}
+
+// windowsReserved are the names Windows won't allow as a file name, with or
+// without an extension.
+var windowsReserved = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// sanitizeComponent makes a single path component valid on Windows.
+func sanitizeComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return -1
+		case strings.ContainsRune(`<>:"/\|?*`, r):
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.TrimRight(s, " .")
+
+	if windowsReserved[strings.ToLower(strings.TrimSuffix(s, filepath.Ext(s)))] {
+		s = "_" + s
+	}
+	return s
+}
+
+// artistInitial returns the upper-case first letter of artist, after
+// replacementsTable is applied to it like to the rest of the path, or "#"
+// when it doesn't start with one.
+func artistInitial(artist string, replacementsTable map[string]string) string {
+	initial := "#"
+	for _, r := range artist {
+		if unicode.IsLetter(r) {
+			initial = string(unicode.ToLower(r))
+		}
+		break
+	}
+	for k, v := range replacementsTable {
+		initial = strings.ReplaceAll(initial, k, v)
+	}
+
+	// a replacement may spell the letter with several, or none at all
+	for _, r := range initial {
+		if unicode.IsLetter(r) {
+			return string(unicode.ToUpper(r))
+		}
+		break
+	}
+	return "#"
+}
+
+func ComputeTargetPath(source tag.Metadata, originalPath string, replacementsTable map[string]string, opts PathOptions) string {
 	var outputDir, outputFile string
 
 	if source == nil {
@@ -22,14 +193,26 @@ func ComputeTargetPath(source tag.Metadata, originalPath string, replacementsTab
 	if source.AlbumArtist() != "" {
 		artist = source.AlbumArtist()
 	}
+	artist = stripArticle(artist, opts.StripArticles, opts.ArticleStyle)
 
 	outputDir += strings.ToLower(fmt.Sprintf("%s-%s", artist, source.Album()))
 
-	outputFile += strings.ToLower(fmt.Sprintf("%s-%s%s",
-		fmt.Sprintf("%02d", track),
-		source.Title(),
-		filepath.Ext(originalPath),
-	))
+	title := source.Title()
+	if opts.StripTrackPrefix {
+		title = stripTrackPrefix(title, track)
+	}
+
+	// extensions are always lower case, whatever case the source file uses
+	ext := strings.ToLower(filepath.Ext(originalPath))
+
+	if track == 0 && opts.MissingTrack == MissingTrackFilename {
+		outputFile = strings.ToLower(filepath.Base(originalPath))
+	} else {
+		outputFile += strings.ToLower(fmt.Sprintf("%s-%s",
+			fmt.Sprintf("%0*d", max(opts.TrackWidth, 2), track),
+			title,
+		)) + ext
+	}
 
 	// is this a multi-album? prepend the file with album number
 	// if source.
@@ -50,10 +233,40 @@ func ComputeTargetPath(source tag.Metadata, originalPath string, replacementsTab
 	outputFile = strings.ReplaceAll(outputFile, "/", "_")
 	outputDir = strings.ReplaceAll(outputDir, "/", "_")
 
+	if opts.CollapseSeparators {
+		ext := filepath.Ext(outputFile)
+		outputFile = collapseSeparators(strings.TrimSuffix(outputFile, ext)) + ext
+		outputDir = collapseSeparators(outputDir)
+	}
+
+	if opts.WindowsSafe {
+		outputFile = sanitizeComponent(outputFile)
+		outputDir = sanitizeComponent(outputDir)
+	}
+
+	// there's no directory component in flat mode, so there's nothing to
+	// truncate either.
+	if opts.Flat {
+		flat := fmt.Sprintf("%s-%s", outputDir, outputFile)
+		// either half may have collapsed to nothing, leaving a stray dash
+		if opts.CollapseSeparators {
+			ext := filepath.Ext(flat)
+			flat = collapseSeparators(strings.TrimSuffix(flat, ext)) + ext
+		}
+		return flat
+	}
+
 	// outputDir should not be too long, otherwise it becomes annoying.
 	if len(outputDir) > 40 {
 		outputDir = outputDir[:40]
+		// cutting it short may have left it ending in a space or a dot
+		if opts.WindowsSafe {
+			outputDir = strings.TrimRight(outputDir, " .")
+		}
 	}
 
+	if opts.ArtistInitial {
+		return filepath.Join(artistInitial(artist, replacementsTable), outputDir, outputFile)
+	}
 	return filepath.Join(outputDir, outputFile)
 }
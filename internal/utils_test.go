@@ -33,37 +33,197 @@ func TestComputeTargetPath(t *testing.T) {
 	tests := []struct {
 		name   string
 		source tag.Metadata
+		opts   PathOptions
 		want   string
 	}{
 		{
 			"missing meta",
 			nil,
+			PathOptions{},
 			"",
 		},
 		{
 			"polish diacritics",
 			mockTag{album: "zażółć", artist: "gęślą", track: 1, title: "jaźń"},
+			PathOptions{},
 			filepath.Join("gesla-zazolc", "01-jazn.flac"),
 		},
 		{
 			"forward slash and space",
 			mockTag{album: "zażółć/gęślą", artist: "jaźń", track: 10, title: "już dziś"},
+			PathOptions{},
 			filepath.Join("jazn-zazolc_gesla", "10-juz_dzis.flac"),
 		},
 		{
 			"mixed case",
 			mockTag{album: "Zażółć", artist: "JaŹń", track: 10, title: "juŻ dziŚ"},
+			PathOptions{},
 			filepath.Join("jazn-zazolc", "10-juz_dzis.flac"),
 		},
 		{
 			"multi-disc",
 			mockTag{album: "zażółć/gęślą", artist: "jaźń", track: 10, tracks: 11, disc: 2, discs: 3, title: "już dziś"},
+			PathOptions{},
 			filepath.Join("jazn-zazolc_gesla", "2-10-juz_dzis.flac"),
 		},
+		{
+			"flat",
+			mockTag{album: "zażółć gęślą jaźń", artist: "jaźń", track: 1, title: "już dziś"},
+			PathOptions{Flat: true},
+			"jazn-zazolc_gesla_jazn-01-juz_dzis.flac",
+		},
+		{
+			"flat long names are not truncated",
+			mockTag{
+				album:  "a very long album title that goes on and on",
+				artist: "an artist with an equally long name",
+				track:  7,
+				title:  "and a title that does not stop either",
+			},
+			PathOptions{Flat: true},
+			"an_artist_with_an_equally_long_name-a_very_long_album_title_that_goes_on_and_on-07-and_a_title_that_does_not_stop_either.flac",
+		},
+		{
+			"matching track prefix in title",
+			mockTag{album: "zażółć", artist: "gęślą", track: 1, title: "01 - Intro"},
+			PathOptions{StripTrackPrefix: true},
+			filepath.Join("gesla-zazolc", "01-intro.flac"),
+		},
+		{
+			"non-matching track prefix in title",
+			mockTag{album: "zażółć", artist: "gęślą", track: 2, title: "99 Luftballons"},
+			PathOptions{StripTrackPrefix: true},
+			filepath.Join("gesla-zazolc", "02-99_luftballons.flac"),
+		},
+		{
+			"track prefix kept by default",
+			mockTag{album: "zażółć", artist: "gęślą", track: 1, title: "01 - Intro"},
+			PathOptions{},
+			filepath.Join("gesla-zazolc", "01-01_-_intro.flac"),
+		},
+		{
+			"collapse separators with empty album",
+			mockTag{artist: "test artist", track: 1, title: "jaźń"},
+			PathOptions{CollapseSeparators: true},
+			filepath.Join("test_artist", "01-jazn.flac"),
+		},
+		{
+			"collapse separators with empty title",
+			mockTag{album: "zażółć", artist: "gęślą", track: 3},
+			PathOptions{CollapseSeparators: true},
+			filepath.Join("gesla-zazolc", "03.flac"),
+		},
+		{
+			"collapse mixed underscores and dashes",
+			mockTag{album: "zażółć -  gęślą", artist: "jaźń_", track: 1, title: "01 - intro"},
+			PathOptions{CollapseSeparators: true},
+			filepath.Join("jazn-zazolc-gesla", "01-01-intro.flac"),
+		},
+		{
+			"collapse separators in flat mode",
+			mockTag{track: 1, title: "t"},
+			PathOptions{CollapseSeparators: true, Flat: true},
+			"01-t.flac",
+		},
+		{
+			"separators kept by default",
+			mockTag{artist: "test artist", track: 1, title: "jaźń"},
+			PathOptions{},
+			filepath.Join("test_artist-", "01-jazn.flac"),
+		},
+		{
+			"windows safe",
+			mockTag{album: "Live...", artist: "AC/DC", track: 1, title: "AC/DC: Live?\t"},
+			PathOptions{WindowsSafe: true},
+			filepath.Join("ac_dc-live", "01-ac_dc__live_.flac"),
+		},
+		{
+			"windows reserved name",
+			mockTag{artist: "con", track: 1, title: "aux"},
+			PathOptions{WindowsSafe: true, CollapseSeparators: true},
+			filepath.Join("_con", "01-aux.flac"),
+		},
+		{
+			"strip articles",
+			mockTag{album: "Soul Mining", artist: "The The", track: 1, title: "Uncertain Smile"},
+			PathOptions{StripArticles: []string{"The", "A", "An"}},
+			filepath.Join("the-soul_mining", "01-uncertain_smile.flac"),
+		},
+		{
+			"strip articles to the end",
+			mockTag{album: "Soul Mining", artist: "The The", track: 1, title: "Uncertain Smile"},
+			PathOptions{StripArticles: []string{"The", "A", "An"}, ArticleStyle: ArticleMoveToEnd},
+			filepath.Join("the,_the-soul_mining", "01-uncertain_smile.flac"),
+		},
+		{
+			"strip articles only as a whole word",
+			mockTag{album: "Tango", artist: "Theatre", albumArtist: "THE  Theatre", track: 1, title: "Act"},
+			PathOptions{StripArticles: []string{"the"}},
+			filepath.Join("theatre-tango", "01-act.flac"),
+		},
+		{
+			"strip articles before the initial",
+			mockTag{album: "Abbey Road", artist: "The Beatles", track: 1, title: "Come Together"},
+			PathOptions{StripArticles: []string{"The"}, ArtistInitial: true},
+			filepath.Join("B", "beatles-abbey_road", "01-come_together.flac"),
+		},
+		{
+			"artist initial",
+			mockTag{album: "Abbey Road", artist: "The Beatles", track: 1, title: "Come Together"},
+			PathOptions{ArtistInitial: true},
+			filepath.Join("T", "the_beatles-abbey_road", "01-come_together.flac"),
+		},
+		{
+			"artist initial from album artist",
+			mockTag{album: "zażółć", artist: "gęślą", albumArtist: "Żółw", track: 1, title: "jaźń"},
+			PathOptions{ArtistInitial: true},
+			filepath.Join("Z", "zolw-zazolc", "01-jazn.flac"),
+		},
+		{
+			"artist initial for a digit",
+			mockTag{album: "Bigger", artist: "4 Non Blondes", track: 1, title: "What's Up"},
+			PathOptions{ArtistInitial: true},
+			filepath.Join("#", "4_non_blondes-bigger", "01-what's_up.flac"),
+		},
+		{
+			"artist initial for an empty artist",
+			mockTag{album: "album", track: 1, title: "title"},
+			PathOptions{ArtistInitial: true},
+			filepath.Join("#", "-album", "01-title.flac"),
+		},
+		{
+			"windows unsafe by default",
+			mockTag{album: "Live...", artist: "AC/DC", track: 1, title: "AC/DC: Live?"},
+			PathOptions{},
+			filepath.Join("ac_dc-live...", "01-ac_dc:_live?.flac"),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := ComputeTargetPath(tt.source, originalPath, replacementsTable); got != tt.want {
+			if got := ComputeTargetPath(tt.source, originalPath, replacementsTable, tt.opts); got != tt.want {
+				t.Errorf("ComputeTargetPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeTargetPathExtension(t *testing.T) {
+	track := mockTag{album: "album", artist: "artist", track: 1, title: "Title"}
+
+	tests := []struct {
+		originalPath string
+		source       mockTag
+		opts         PathOptions
+		want         string
+	}{
+		{"/music/track.FLAC", track, PathOptions{}, filepath.Join("artist-album", "01-title.flac")},
+		{"/music/track.Mp3", track, PathOptions{}, filepath.Join("artist-album", "01-title.mp3")},
+		{"/music/track.Mp3", track, PathOptions{CollapseSeparators: true}, filepath.Join("artist-album", "01-title.mp3")},
+		{"/music/Track.FLAC", mockTag{album: "album", artist: "artist"}, PathOptions{MissingTrack: MissingTrackFilename}, filepath.Join("artist-album", "track.flac")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.originalPath, func(t *testing.T) {
+			if got := ComputeTargetPath(tt.source, tt.originalPath, nil, tt.opts); got != tt.want {
 				t.Errorf("ComputeTargetPath() = %v, want %v", got, tt.want)
 			}
 		})
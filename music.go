@@ -1,9 +1,11 @@
 package musictagger
 
 import (
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/dhowden/tag"
 )
@@ -13,30 +15,120 @@ type Music struct {
 	Metadata tag.Metadata
 }
 
+// Filter reports whether a file or directory found while scanning should be
+// looked at. Returning false for a directory skips it and everything under
+// it.
+type Filter func(path string, d fs.DirEntry) bool
+
+// IgnorePatterns returns a Filter that rejects anything whose base name
+// matches one of the given filepath.Match patterns, e.g. "*.part" or ".*".
+func IgnorePatterns(patterns ...string) Filter {
+	return func(path string, d fs.DirEntry) bool {
+		for _, p := range patterns {
+			if ok, _ := filepath.Match(p, d.Name()); ok {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// DefaultAudioExtensions are the extensions of the files probed for tags by
+// default. WAV isn't one of them, since tag can't read its tags.
+var DefaultAudioExtensions = []string{"mp3", "flac", "m4a", "ogg", "opus"}
+
+// HasExtension reports whether path has one of the given extensions. Matching
+// is case-insensitive and the leading dot is optional.
+func HasExtension(path string, exts []string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	for _, e := range exts {
+		if strings.EqualFold(ext, strings.TrimPrefix(e, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// AudioFiles returns a Filter that only lets through files with one of the
+// given extensions, so that e.g. .cue or .log files are never probed for
+// tags. Directories always pass.
+func AudioFiles(exts ...string) Filter {
+	return func(path string, d fs.DirEntry) bool {
+		return d.IsDir() || HasExtension(path, exts)
+	}
+}
+
+// Accept reports whether path passes all filters.
+func Accept(filters []Filter, path string, d fs.DirEntry) bool {
+	for _, f := range filters {
+		if !f(path, d) {
+			return false
+		}
+	}
+	return true
+}
+
+// FileError is a file whose tags couldn't be read.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e FileError) Error() string {
+	return fmt.Sprintf("reading tags of %s: %v", e.Path, e.Err)
+}
+
+func (e FileError) Unwrap() error {
+	return e.Err
+}
+
 // GetAllTags traverses a given directory recursively and extracts all tags it
-// can find. It returns a map of album directory to music.
-func GetAllTags(dir string) (map[string][]Music, error) {
+// can find. It returns a map of album directory to music, along with the files
+// whose tags couldn't be read. Files and directories rejected by any of the
+// filters are skipped.
+func GetAllTags(dir string, filters ...Filter) (map[string][]Music, []FileError, error) {
 	tags := map[string][]Music{}
-	if err := filepath.WalkDir(dir, func(s string, d fs.DirEntry, err error) error {
+	var failed []FileError
+	err := GetAllTagsFunc(dir, func(m Music, err error) error {
+		if err != nil {
+			failed = append(failed, FileError{m.Path, err})
+			return nil
+		}
+		tags[filepath.Dir(m.Path)] = append(tags[filepath.Dir(m.Path)], m)
+		return nil
+	}, filters...)
+	return tags, failed, err
+}
+
+// GetAllTagsFunc is GetAllTags, but instead of collecting the music it calls
+// fn for every file as soon as it's read. If the file's tags couldn't be
+// read, fn gets the error and a Music without Metadata. If fn returns an
+// error, the traversal stops and GetAllTagsFunc returns that error.
+func GetAllTagsFunc(dir string, fn func(Music, error) error, filters ...Filter) error {
+	return filepath.WalkDir(dir, func(s string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if !d.IsDir() {
-			f, err := os.Open(s)
-			if err != nil {
-				return err
+		if s != dir && !Accept(filters, s, d) {
+			if d.IsDir() {
+				return filepath.SkipDir
 			}
-			defer f.Close()
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
 
-			m, _ := tag.ReadFrom(f)
-			if m != nil {
-				tags[filepath.Dir(s)] = append(tags[filepath.Dir(s)], Music{s, m})
-			}
+		f, err := os.Open(s)
+		if err != nil {
+			return err
 		}
-		return nil
-	}); err != nil {
-		return tags, err
-	}
+		defer f.Close()
 
-	return tags, nil
+		m, err := tag.ReadFrom(f)
+		if err != nil {
+			return fn(Music{Path: s}, err)
+		}
+		return fn(Music{s, m}, nil)
+	})
 }
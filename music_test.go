@@ -0,0 +1,167 @@
+package musictagger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// writeID3v1 writes a minimal mp3 file carrying an ID3v1 tag.
+func writeID3v1(t *testing.T, path, title, artist, album string, track byte) {
+	t.Helper()
+
+	field := func(s string, n int) []byte {
+		b := make([]byte, n)
+		copy(b, s)
+		return b
+	}
+
+	b := []byte{0xff, 0xfb}
+	b = append(b, make([]byte, 200)...)
+	b = append(b, "TAG"...)
+	b = append(b, field(title, 30)...)
+	b = append(b, field(artist, 30)...)
+	b = append(b, field(album, 30)...)
+	b = append(b, field("1999", 4)...)
+	b = append(b, field("", 28)...)
+	b = append(b, 0, track, 0)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func musicPaths(tags map[string][]Music) []string {
+	var paths []string
+	for _, music := range tags {
+		for _, m := range music {
+			paths = append(paths, m.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestGetAllTagsIgnorePatterns(t *testing.T) {
+	dir := t.TempDir()
+	writeID3v1(t, filepath.Join(dir, "album", "track.mp3"), "title", "artist", "album", 1)
+	writeID3v1(t, filepath.Join(dir, "album", "track.mp3.part"), "title", "artist", "album", 1)
+	writeID3v1(t, filepath.Join(dir, "album", ".hidden.mp3"), "title", "artist", "album", 1)
+	writeID3v1(t, filepath.Join(dir, "album", "@eaDir", "track.mp3"), "title", "artist", "album", 1)
+
+	tags, _, err := GetAllTags(dir, IgnorePatterns("*.part", "@eaDir", ".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := musicPaths(tags)
+	want := []string{filepath.Join(dir, "album", "track.mp3")}
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Errorf("GetAllTags() = %v, want %v", got, want)
+	}
+}
+
+func TestGetAllTagsAudioFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeID3v1(t, filepath.Join(dir, "album", "track.opus"), "title", "artist", "album", 1)
+	writeID3v1(t, filepath.Join(dir, "album", "other.MP3"), "title", "artist", "album", 2)
+	// the tag reader would happily read this one, but it's not music
+	writeID3v1(t, filepath.Join(dir, "album", "album.cue"), "title", "artist", "album", 1)
+	// nor is this one probed, as there's no reading its tags
+	if err := os.WriteFile(filepath.Join(dir, "album", "track.wav"), []byte("RIFF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, failed, err := GetAllTags(dir, AudioFiles(DefaultAudioExtensions...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) > 0 {
+		t.Errorf("GetAllTags() failed on %v", failed)
+	}
+
+	got := musicPaths(tags)
+	want := []string{
+		filepath.Join(dir, "album", "other.MP3"),
+		filepath.Join(dir, "album", "track.opus"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAllTags() = %v, want %v", got, want)
+	}
+}
+
+func TestGetAllTagsFunc(t *testing.T) {
+	dir := t.TempDir()
+	writeID3v1(t, filepath.Join(dir, "a", "track.mp3"), "title", "artist", "a", 1)
+	writeID3v1(t, filepath.Join(dir, "b", "track.mp3"), "title", "artist", "b", 1)
+	writeID3v1(t, filepath.Join(dir, "c", "track.mp3"), "title", "artist", "c", 1)
+	if err := os.WriteFile(filepath.Join(dir, "a", "notes.txt"), []byte("not music"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("calls fn for every music file", func(t *testing.T) {
+		var got []string
+		err := GetAllTagsFunc(dir, func(m Music, err error) error {
+			if err != nil {
+				return err
+			}
+			got = append(got, m.Path)
+			return nil
+		}, AudioFiles(DefaultAudioExtensions...))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{
+			filepath.Join(dir, "a", "track.mp3"),
+			filepath.Join(dir, "b", "track.mp3"),
+			filepath.Join(dir, "c", "track.mp3"),
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("GetAllTagsFunc() visited %v, want %v", got, want)
+		}
+	})
+
+	t.Run("stops on error", func(t *testing.T) {
+		stop := errors.New("stop")
+		calls := 0
+		err := GetAllTagsFunc(dir, func(m Music, err error) error {
+			calls++
+			return stop
+		})
+		if !errors.Is(err, stop) {
+			t.Errorf("GetAllTagsFunc() error = %v, want %v", err, stop)
+		}
+		if calls != 1 {
+			t.Errorf("fn called %d times, want 1", calls)
+		}
+	})
+}
+
+func TestGetAllTagsReadErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeID3v1(t, filepath.Join(dir, "album", "good.mp3"), "title", "artist", "album", 1)
+	// an ID3v2 header promising far more tag data than there is
+	corrupt := filepath.Join(dir, "album", "corrupt.mp3")
+	if err := os.WriteFile(corrupt, []byte("ID3\x03\x00\x00\x7f\x7f\x7f\x7fgarbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, failed, err := GetAllTags(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := musicPaths(tags), []string{filepath.Join(dir, "album", "good.mp3")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetAllTags() = %v, want %v", got, want)
+	}
+	if len(failed) != 1 || failed[0].Path != corrupt || failed[0].Err == nil {
+		t.Errorf("GetAllTags() read errors = %v, want one for %s", failed, corrupt)
+	}
+}
@@ -0,0 +1,63 @@
+package musictagger
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// AlbumNFOFile is the name of the file WriteNFO creates.
+const AlbumNFOFile = "album.nfo"
+
+// albumNFO is the subset of the Kodi album.nfo format that Jellyfin and Plex
+// scrapers read.
+type albumNFO struct {
+	XMLName     xml.Name   `xml:"album"`
+	Title       string     `xml:"title"`
+	Artist      string     `xml:"artist"`
+	AlbumArtist string     `xml:"albumartist"`
+	Year        int        `xml:"year,omitempty"`
+	Genre       string     `xml:"genre,omitempty"`
+	Tracks      []nfoTrack `xml:"track"`
+}
+
+type nfoTrack struct {
+	Disc     int    `xml:"cdnum,omitempty"`
+	Position int    `xml:"position"`
+	Title    string `xml:"title"`
+}
+
+// WriteNFO writes the album as album.nfo in dir, unless there already is one,
+// which is left alone.
+func (a Album) WriteNFO(dir string) error {
+	nfo := albumNFO{
+		Title:       a.Album,
+		Artist:      a.Artist,
+		AlbumArtist: a.Artist,
+		Year:        a.Year,
+		Genre:       a.Genre,
+	}
+	for _, t := range a.Tracks {
+		nfo.Tracks = append(nfo.Tracks, nfoTrack{Disc: t.Disc, Position: t.Number, Title: t.Title})
+	}
+
+	b, err := xml.MarshalIndent(nfo, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, AlbumNFOFile), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append([]byte(xml.Header), b...)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}